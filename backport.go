@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// backportSettings configures `mudlark backport`: the git identity to
+// cherry-pick as, the fork org that backport branches get pushed to
+// before the downstream pull request is opened, and an optional PR-body
+// template.
+type backportSettings struct {
+	GitUserName  string `yaml:"gitUserName"`
+	GitUserEmail string `yaml:"gitUserEmail"`
+	ForkOrg      string `yaml:"forkOrg"`
+	TemplatePath string `yaml:"templatePath"`
+}
+
+// validateBackportSettings checks that the backport: block has enough
+// configured to actually run a backport. loadSettings doesn't call this,
+// since most mudlark invocations never need it; runBackport does.
+func validateBackportSettings(settings *backportSettings, filename string) error {
+	if settings == nil {
+		return fmt.Errorf("No backport block found in %s", filename)
+	}
+	if settings.GitUserName == "" || settings.GitUserEmail == "" {
+		return fmt.Errorf("backport.gitUserName and backport.gitUserEmail must both be set in %s", filename)
+	}
+	if settings.ForkOrg == "" {
+		return fmt.Errorf("No backport.forkOrg found in %s", filename)
+	}
+	return nil
+}
+
+// backportCandidate is a merged upstream pull request with no matching
+// downstream pull request yet.
+type backportCandidate struct {
+	ref     ForgeRef
+	url     string
+	title   string
+	baseRef string
+}
+
+// findBackportCandidates walks an issueResult tree looking for upstream
+// pull/merge requests that are merged but have no downstream match: the
+// merged subset of what the text renderer reports as "no matching pull
+// requests found", which also covers upstream pull requests still open.
+func findBackportCandidates(settings *appSettings, result *issueResult) []backportCandidate {
+	candidates := []backportCandidate{}
+
+	var walkLinks func(links []*linkResult)
+	walkLinks = func(links []*linkResult) {
+		for _, link := range links {
+			forgeCfg := settings.forgeFor(link.ref.Host)
+			if link.ref.Host == forgeCfg.downstreamHost() && link.ref.Org == forgeCfg.DownstreamOrg {
+				continue
+			}
+			if link.prWithStatus.status == "merged" && len(link.others) == 0 {
+				candidates = append(candidates, backportCandidate{
+					ref:     link.ref,
+					url:     link.url,
+					title:   link.prWithStatus.pull.Title,
+					baseRef: link.prWithStatus.pull.BaseRef,
+				})
+				continue
+			}
+			walkLinks(link.others)
+		}
+	}
+	walkLinks(result.linkResults)
+
+	for _, child := range result.children {
+		candidates = append(candidates, findBackportCandidates(settings, child)...)
+	}
+	return candidates
+}
+
+// backportWorktreeDir is where mudlark stages the cherry-picks for one
+// upstream pull request. Using a deterministic path (rather than a temp
+// dir) is what lets `mudlark backport --continue` find the same worktree
+// a conflicted run left behind.
+func backportWorktreeDir(cacheDir, issueKey string, ref ForgeRef) string {
+	return filepath.Join(cacheDir, "mudlark", "worktrees", fmt.Sprintf("%s-%d", issueKey, ref.ID))
+}
+
+func backportBranchName(issueKey string, ref ForgeRef) string {
+	return fmt.Sprintf("backport/%s-%d", issueKey, ref.ID)
+}
+
+func forgeCloneURL(host, org, repo string) string {
+	return fmt.Sprintf("https://%s/%s/%s.git", host, org, repo)
+}
+
+// gitStep is one command mudlark will run as part of a backport, kept as
+// data so dry-run mode can print the exact same steps it would otherwise
+// execute.
+type gitStep struct {
+	dir  string
+	args []string
+}
+
+func (s gitStep) String() string {
+	if s.dir == "" {
+		return fmt.Sprintf("git %s", strings.Join(s.args, " "))
+	}
+	return fmt.Sprintf("git -C %s %s", s.dir, strings.Join(s.args, " "))
+}
+
+func runGitStep(step gitStep) error {
+	cmd := exec.Command("git", step.args...)
+	cmd.Dir = step.dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v\n%s", step, err, out.String())
+	}
+	return nil
+}
+
+const defaultBackportBodyTemplate = `Backport of {{.UpstreamURL}} for {{.IssueKey}}.
+
+{{.IssueURL}}
+`
+
+// backportBodyData is what a custom backport.templatePath file is rendered
+// against.
+type backportBodyData struct {
+	IssueKey    string
+	IssueURL    string
+	UpstreamURL string
+}
+
+func renderBackportBody(settings *appSettings, issueKey, upstreamURL string) (string, error) {
+	tmplText := defaultBackportBodyTemplate
+	if settings.Backport.TemplatePath != "" {
+		content, err := ioutil.ReadFile(settings.Backport.TemplatePath)
+		if err != nil {
+			return "", err
+		}
+		tmplText = string(content)
+	}
+
+	tmpl, err := template.New("backport-body").Parse(tmplText)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, backportBodyData{
+		IssueKey:    issueKey,
+		IssueURL:    fmt.Sprintf("%s/browse/%s", settings.Jira.URL, issueKey),
+		UpstreamURL: upstreamURL,
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// runBackport implements `mudlark backport ISSUE`: for every merged
+// upstream pull request linked from issueID that has no downstream
+// equivalent, it stages a worktree, cherry-picks the upstream commits,
+// pushes the result to the configured fork, and opens a downstream pull
+// request linking back to the Jira issue and the upstream PR.
+func runBackport(settings *appSettings, clients *serviceClients, cache *cache, issueID string, dryRun, continueRun bool) error {
+	if err := validateBackportSettings(settings.Backport, "the backport: block"); err != nil {
+		return err
+	}
+
+	result, err := processOneIssue(settings, clients, cache, issueID)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not fetch %s", issueID))
+	}
+
+	candidates := findBackportCandidates(settings, result)
+	if len(candidates) == 0 {
+		fmt.Printf("no upstream pull requests need backporting for %s\n", issueID)
+		return nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, candidate := range candidates {
+		if err := backportOne(ctx, settings, clients, cacheDir, issueID, candidate, dryRun, continueRun); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not backport %s", candidate.url))
+		}
+	}
+	return nil
+}
+
+func backportOne(ctx context.Context, settings *appSettings, clients *serviceClients, cacheDir, issueKey string, candidate backportCandidate, dryRun, continueRun bool) error {
+	ref := candidate.ref
+	forgeCfg := settings.forgeFor(ref.Host)
+	forgeClient, ok := clients.forges[ref.Host]
+	if !ok {
+		return fmt.Errorf("no forge configured for host %q", ref.Host)
+	}
+	downstreamHost := forgeCfg.downstreamHost()
+	downstreamClient, ok := clients.forges[downstreamHost]
+	if !ok {
+		return fmt.Errorf("no forge configured for downstream host %q", downstreamHost)
+	}
+
+	worktreeDir := backportWorktreeDir(cacheDir, issueKey, ref)
+	branch := backportBranchName(issueKey, ref)
+	downstreamRemote := forgeCloneURL(downstreamHost, forgeCfg.DownstreamOrg, ref.Repo)
+	upstreamRemote := forgeCloneURL(ref.Host, ref.Org, ref.Repo)
+	forkRemote := forgeCloneURL(downstreamHost, settings.Backport.ForkOrg, ref.Repo)
+
+	fmt.Printf("\nbackporting %s to %s/%s as %s\n", candidate.url, forgeCfg.DownstreamOrg, ref.Repo, branch)
+
+	if continueRun {
+		step := gitStep{worktreeDir, []string{"cherry-pick", "--continue"}}
+		if dryRun {
+			fmt.Println(step)
+		} else if err := runGitStep(step); err != nil {
+			return fmt.Errorf("cherry-pick --continue still has unresolved conflicts in %s; resolve them and re-run with --continue: %v",
+				worktreeDir, err)
+		}
+	} else {
+		commits, err := forgeClient.ListCommits(ctx, ref.Org, ref.Repo, ref.ID)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not list commits for %s", candidate.url))
+		}
+		shas := make([]string, len(commits))
+		for i, c := range commits {
+			shas[i] = c.SHA
+		}
+
+		steps := []gitStep{}
+		if fileExists(filepath.Join(worktreeDir, ".git")) {
+			// The worktree survived an earlier run (e.g. a failed push),
+			// so upstream/fork remotes are already configured; adding
+			// them again would fail with "remote ... already exists".
+			steps = append(steps, gitStep{worktreeDir, []string{"fetch", "downstream"}})
+		} else {
+			steps = append(steps,
+				gitStep{"", []string{"clone", "--origin", "downstream", downstreamRemote, worktreeDir}},
+				gitStep{worktreeDir, []string{"remote", "add", "upstream", upstreamRemote}},
+				gitStep{worktreeDir, []string{"remote", "add", "fork", forkRemote}},
+			)
+		}
+		steps = append(steps,
+			gitStep{worktreeDir, []string{"fetch", "upstream"}},
+			gitStep{worktreeDir, []string{"checkout", "-b", branch, fmt.Sprintf("downstream/%s", candidate.baseRef)}},
+			gitStep{worktreeDir, append([]string{
+				"-c", fmt.Sprintf("user.name=%s", settings.Backport.GitUserName),
+				"-c", fmt.Sprintf("user.email=%s", settings.Backport.GitUserEmail),
+				"cherry-pick", "-x",
+			}, shas...)},
+		)
+
+		if dryRun {
+			for _, step := range steps {
+				fmt.Println(step)
+			}
+			fmt.Println(gitStep{worktreeDir, []string{"push", "fork", branch}})
+			fmt.Printf("  (then open a pull request from %s:%s against %s/%s@%s)\n",
+				settings.Backport.ForkOrg, branch, forgeCfg.DownstreamOrg, ref.Repo, candidate.baseRef)
+			return nil
+		}
+
+		cherryPickStep := len(steps) - 1
+		for i, step := range steps {
+			if err := runGitStep(step); err != nil {
+				if i == cherryPickStep {
+					return fmt.Errorf("cherry-pick of %s conflicted; resolve it in %s and re-run mudlark backport with --continue: %v",
+						candidate.url, worktreeDir, err)
+				}
+				return err
+			}
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	if err := runGitStep(gitStep{worktreeDir, []string{"push", "fork", branch}}); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not push %s to the fork", branch))
+	}
+
+	body, err := renderBackportBody(settings, issueKey, candidate.url)
+	if err != nil {
+		return errors.Wrap(err, "could not render backport pull request body")
+	}
+
+	pr, err := downstreamClient.CreatePullRequest(ctx, forgeCfg.DownstreamOrg, ref.Repo,
+		fmt.Sprintf("Backport of %s for %s", candidate.title, issueKey),
+		fmt.Sprintf("%s:%s", settings.Backport.ForkOrg, branch),
+		candidate.baseRef,
+		body,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not open downstream pull request")
+	}
+	fmt.Printf("  opened %s\n", pr.HTMLURL)
+	return nil
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// Credential knows how to authenticate an outgoing HTTP request for one
+// account on one service, and how to recover when the service reports that
+// the credential has gone stale.
+type Credential interface {
+	// Apply adds whatever headers or cookies are needed to authenticate
+	// req.
+	Apply(req *http.Request) error
+	// Refresh re-derives or re-fetches the credential's state. It is
+	// called automatically the first time a request comes back with a
+	// 401, and is a no-op for credentials that can't be refreshed (for
+	// example a fixed personal access token).
+	Refresh(ctx context.Context) error
+}
+
+// credentialTransport wraps an underlying http.RoundTripper, applying a
+// Credential to every outgoing request and retrying once, after a
+// Refresh, if the server responds 401 Unauthorized.
+type credentialTransport struct {
+	credential Credential
+	base       http.RoundTripper
+}
+
+func (t *credentialTransport) client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	attempt := req.Clone(req.Context())
+	if err := t.credential.Apply(attempt); err != nil {
+		return nil, errors.Wrap(err, "could not apply credential")
+	}
+
+	resp, err := base.RoundTrip(attempt)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	if err := t.credential.Refresh(req.Context()); err != nil {
+		return resp, errors.Wrap(err, "could not refresh credential after 401")
+	}
+
+	retry := req.Clone(req.Context())
+	if err := t.credential.Apply(retry); err != nil {
+		return nil, errors.Wrap(err, "could not apply refreshed credential")
+	}
+	return base.RoundTrip(retry)
+}
+
+// basicAuthCredential sends a fixed username and password on every
+// request. It never needs refreshing.
+type basicAuthCredential struct {
+	User     string
+	Password string
+}
+
+func (c *basicAuthCredential) Apply(req *http.Request) error {
+	req.SetBasicAuth(c.User, c.Password)
+	return nil
+}
+
+func (c *basicAuthCredential) Refresh(ctx context.Context) error {
+	return nil
+}
+
+// tokenCredential sends a fixed bearer token on every request, such as a
+// JIRA or GitHub personal access token. It never needs refreshing.
+type tokenCredential struct {
+	Token string
+}
+
+func (c *tokenCredential) Apply(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	return nil
+}
+
+func (c *tokenCredential) Refresh(ctx context.Context) error {
+	return nil
+}
+
+// sessionCookieCredential logs in to JIRA's session API with a username
+// and password once, then presents the resulting JSESSIONID cookie on
+// every request. Refresh logs in again, which is what lets mudlark
+// recover from the cookie expiring mid-run.
+type sessionCookieCredential struct {
+	LoginURL string
+	User     string
+	Password string
+
+	mutex  sync.Mutex
+	cookie *http.Cookie
+}
+
+func (c *sessionCookieCredential) Apply(req *http.Request) error {
+	c.mutex.Lock()
+	cookie := c.cookie
+	c.mutex.Unlock()
+
+	if cookie == nil {
+		if err := c.Refresh(req.Context()); err != nil {
+			return errors.Wrap(err, "could not establish JIRA session")
+		}
+		c.mutex.Lock()
+		cookie = c.cookie
+		c.mutex.Unlock()
+	}
+
+	req.AddCookie(cookie)
+	return nil
+}
+
+func (c *sessionCookieCredential) Refresh(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	body, err := json.Marshal(map[string]string{
+		"username": c.User,
+		"password": c.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.LoginURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not create JIRA session")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not create JIRA session: %s", resp.Status)
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "JSESSIONID" {
+			c.cookie = cookie
+			return nil
+		}
+	}
+	return fmt.Errorf("JIRA session response did not include a JSESSIONID cookie")
+}
+
+// oauth1Token is the pair persisted to oauth1Credential.TokenFile once the
+// three-legged OAuth 1.0a handshake has been completed.
+type oauth1Token struct {
+	Token       string `json:"token"`
+	TokenSecret string `json:"tokenSecret"`
+}
+
+// oauth1Credential implements Atlassian's OAuth 1.0a signed-request flow:
+// RSA-SHA1 over the request, a consumer key, and an access token obtained
+// once via the request-token/authorize/access-token dance at
+// /plugins/servlet/oauth/*.
+type oauth1Credential struct {
+	BaseURL        string
+	ConsumerKey    string
+	PrivateKeyFile string
+	TokenFile      string
+
+	mutex      sync.Mutex
+	privateKey *rsa.PrivateKey
+	token      *oauth1Token
+}
+
+func newOAuth1Credential(baseURL string, settings *jiraOAuth1Settings) (*oauth1Credential, error) {
+	keyData, err := ioutil.ReadFile(settings.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read oauth1 private key")
+	}
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode PEM block in %s", settings.PrivateKeyFile)
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse oauth1 private key")
+	}
+
+	cred := &oauth1Credential{
+		BaseURL:        baseURL,
+		ConsumerKey:    settings.ConsumerKey,
+		PrivateKeyFile: settings.PrivateKeyFile,
+		TokenFile:      settings.TokenFile,
+		privateKey:     privateKey,
+	}
+
+	if fileExists(settings.TokenFile) {
+		token, err := loadOAuth1Token(settings.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		cred.token = token
+	}
+
+	return cred, nil
+}
+
+func loadOAuth1Token(filename string) (*oauth1Token, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read oauth1 token file")
+	}
+	token := &oauth1Token{}
+	if err := json.Unmarshal(content, token); err != nil {
+		return nil, errors.Wrap(err, "could not parse oauth1 token file")
+	}
+	return token, nil
+}
+
+func saveOAuth1Token(filename string, token *oauth1Token) error {
+	content, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, content, 0600)
+}
+
+func (c *oauth1Credential) Apply(req *http.Request) error {
+	c.mutex.Lock()
+	token := c.token
+	c.mutex.Unlock()
+
+	if token == nil {
+		return fmt.Errorf("no oauth1 access token in %s; run `mudlark -jira-authorize` first", c.TokenFile)
+	}
+
+	params := map[string]string{
+		"oauth_consumer_key":     c.ConsumerKey,
+		"oauth_token":            token.Token,
+		"oauth_signature_method": "RSA-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_nonce":            oauthNonce(),
+		"oauth_version":          "1.0",
+	}
+
+	signature, err := c.sign(req.Method, req.URL, params)
+	if err != nil {
+		return errors.Wrap(err, "could not sign oauth1 request")
+	}
+	params["oauth_signature"] = signature
+
+	req.Header.Set("Authorization", buildOAuth1Header(params))
+	return nil
+}
+
+// Refresh re-reads the token file, in case a concurrent `-jira-authorize`
+// run (or a person editing the file by hand) has replaced it. Atlassian's
+// OAuth 1.0a access tokens do not expire on their own, so there is nothing
+// else to renew here.
+func (c *oauth1Credential) Refresh(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !fileExists(c.TokenFile) {
+		return fmt.Errorf("no oauth1 access token in %s; run `mudlark -jira-authorize` first", c.TokenFile)
+	}
+	token, err := loadOAuth1Token(c.TokenFile)
+	if err != nil {
+		return err
+	}
+	c.token = token
+	return nil
+}
+
+// sign computes the RSA-SHA1 OAuth 1.0a signature for a request, per
+// https://oauth.net/core/1.0a/#signing_process.
+func (c *oauth1Credential) sign(method string, target *url.URL, oauthParams map[string]string) (string, error) {
+	params := make(map[string][]string)
+	for key, values := range target.Query() {
+		params[key] = values
+	}
+	for key, value := range oauthParams {
+		params[key] = append(params[key], value)
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		values := params[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	normalizedParams := strings.Join(pairs, "&")
+
+	baseURL := *target
+	baseURL.RawQuery = ""
+	baseString := strings.ToUpper(method) + "&" +
+		url.QueryEscape(baseURL.String()) + "&" +
+		url.QueryEscape(normalizedParams)
+
+	hashed := sha1.Sum([]byte(baseString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA1, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+var oauth1HeaderParamOrder = []string{
+	"oauth_consumer_key",
+	"oauth_token",
+	"oauth_signature_method",
+	"oauth_timestamp",
+	"oauth_nonce",
+	"oauth_version",
+	"oauth_signature",
+}
+
+func buildOAuth1Header(params map[string]string) string {
+	pieces := make([]string, 0, len(oauth1HeaderParamOrder))
+	for _, key := range oauth1HeaderParamOrder {
+		value, ok := params[key]
+		if !ok {
+			continue
+		}
+		pieces = append(pieces, fmt.Sprintf("%s=%q", key, url.QueryEscape(value)))
+	}
+	return "OAuth " + strings.Join(pieces, ", ")
+}
+
+func oauthNonce() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		// Falling back to the timestamp keeps Apply working even if
+		// the system's random source is briefly unavailable; it is
+		// still unique enough to satisfy a nonce in practice.
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// authorizeOAuth1 walks a user through Atlassian's three-legged OAuth 1.0a
+// handshake on the command line and persists the resulting access token to
+// settings.TokenFile, mode 0600, so future runs can use it unattended.
+func authorizeOAuth1(baseURL string, settings *jiraOAuth1Settings) error {
+	cred, err := newOAuth1Credential(baseURL, settings)
+	if err != nil {
+		return err
+	}
+
+	requestToken, requestSecret, err := cred.fetchRequestToken()
+	if err != nil {
+		return errors.Wrap(err, "could not fetch oauth1 request token")
+	}
+
+	fmt.Printf("Open this URL in a browser, approve access, and paste the verification code here:\n\n  %s/plugins/servlet/oauth/authorize?oauth_token=%s\n\n",
+		baseURL, url.QueryEscape(requestToken))
+	fmt.Print("Verification code: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	verifier, err := reader.ReadString('\n')
+	if err != nil {
+		return errors.Wrap(err, "could not read verification code")
+	}
+	verifier = strings.TrimSpace(verifier)
+
+	token, err := cred.fetchAccessToken(requestToken, requestSecret, verifier)
+	if err != nil {
+		return errors.Wrap(err, "could not exchange oauth1 request token for an access token")
+	}
+
+	if err := saveOAuth1Token(settings.TokenFile, token); err != nil {
+		return errors.Wrap(err, "could not save oauth1 access token")
+	}
+	fmt.Printf("Saved oauth1 access token to %s\n", settings.TokenFile)
+	return nil
+}
+
+// fetchRequestToken performs the first leg of the handshake: obtaining a
+// temporary request token that the user then approves in a browser.
+func (c *oauth1Credential) fetchRequestToken() (token, secret string, err error) {
+	values, err := c.doOAuth1TokenRequest(c.BaseURL+"/plugins/servlet/oauth/request-token", "")
+	if err != nil {
+		return "", "", err
+	}
+	return values.Get("oauth_token"), values.Get("oauth_token_secret"), nil
+}
+
+// fetchAccessToken performs the third leg of the handshake: exchanging an
+// authorized request token and the verifier the user copied out of their
+// browser for a long-lived access token.
+func (c *oauth1Credential) fetchAccessToken(requestToken, requestSecret, verifier string) (*oauth1Token, error) {
+	c.mutex.Lock()
+	c.token = &oauth1Token{Token: requestToken, TokenSecret: requestSecret}
+	c.mutex.Unlock()
+
+	values, err := c.doOAuth1TokenRequest(c.BaseURL+"/plugins/servlet/oauth/access-token", verifier)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth1Token{
+		Token:       values.Get("oauth_token"),
+		TokenSecret: values.Get("oauth_token_secret"),
+	}, nil
+}
+
+func (c *oauth1Credential) doOAuth1TokenRequest(endpoint, verifier string) (url.Values, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Apply(req); err != nil {
+		return nil, err
+	}
+	if verifier != "" {
+		req.Header.Set("Authorization", req.Header.Get("Authorization")+fmt.Sprintf(", oauth_verifier=%q", url.QueryEscape(verifier)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oauth1 token request to %s failed: %s", endpoint, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(body))
+}
+
+// githubAppCredential authenticates as a GitHub App installation rather
+// than a user. It signs a short-lived JWT with the app's private key,
+// exchanges it for an installation access token, and transparently fetches
+// a new one once the cached token has expired.
+type githubAppCredential struct {
+	AppID          string
+	InstallationID string
+	PrivateKeyFile string
+
+	mutex      sync.Mutex
+	privateKey *rsa.PrivateKey
+	token      string
+	expiresAt  time.Time
+}
+
+func newGithubAppCredential(settings *githubAppSettings) (*githubAppCredential, error) {
+	keyData, err := ioutil.ReadFile(settings.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read github app private key")
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse github app private key")
+	}
+	return &githubAppCredential{
+		AppID:          settings.AppID,
+		InstallationID: settings.InstallationID,
+		PrivateKeyFile: settings.PrivateKeyFile,
+		privateKey:     privateKey,
+	}, nil
+}
+
+func (c *githubAppCredential) Apply(req *http.Request) error {
+	c.mutex.Lock()
+	token := c.token
+	expiresAt := c.expiresAt
+	c.mutex.Unlock()
+
+	if token == "" || time.Now().After(expiresAt) {
+		if err := c.Refresh(req.Context()); err != nil {
+			return err
+		}
+		c.mutex.Lock()
+		token = c.token
+		c.mutex.Unlock()
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	return nil
+}
+
+func (c *githubAppCredential) Refresh(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    c.AppID,
+		IssuedAt:  jwt.NewNumericDate(now.Add(-30 * time.Second)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+	}
+	appJWT := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	signed, err := appJWT.SignedString(c.privateKey)
+	if err != nil {
+		return errors.Wrap(err, "could not sign github app jwt")
+	}
+
+	endpoint := fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", c.InstallationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+signed)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not fetch github app installation token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("could not fetch github app installation token: %s", resp.Status)
+	}
+
+	var body struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return errors.Wrap(err, "could not parse github app installation token response")
+	}
+
+	c.token = body.Token
+	c.expiresAt = body.ExpiresAt
+	return nil
+}
+
+// buildJiraCredential turns the single configured variant of the jira:
+// YAML block into a Credential.
+func buildJiraCredential(settings *jiraSettings) (Credential, error) {
+	switch {
+	case settings.User != "" || settings.Password != "":
+		return &basicAuthCredential{User: settings.User, Password: settings.Password}, nil
+	case settings.Token != "":
+		return &tokenCredential{Token: settings.Token}, nil
+	case settings.SessionUser != "" || settings.SessionPassword != "":
+		return &sessionCookieCredential{
+			LoginURL: settings.URL + "/rest/auth/1/session",
+			User:     settings.SessionUser,
+			Password: settings.SessionPassword,
+		}, nil
+	case settings.OAuth1 != nil:
+		return newOAuth1Credential(settings.URL, settings.OAuth1)
+	}
+	return nil, fmt.Errorf("no jira credential configured")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var diskCacheBucket = []byte("repoPRCache")
+
+// persistedRepoCache is the on-disk record for one {forge, org, repo}: the
+// pull/merge requests and commits mudlark has already fetched, the ETag to
+// replay as If-None-Match on the next sync, and the time the last sync
+// ran so the next run can ask for only what changed since.
+type persistedRepoCache struct {
+	PullRequests []*ForgePullRequest
+	Commits      map[int][]*ForgeCommit
+	ETag         string
+	SyncedAt     time.Time
+}
+
+// diskCache is a BoltDB-backed store under os.UserCacheDir()/mudlark/ that
+// survives between runs, so cache.getDetails doesn't have to repaginate
+// every pull/merge request in a repo on every invocation.
+type diskCache struct {
+	db *bolt.DB
+}
+
+// openDiskCache opens (creating if necessary) the on-disk cache database
+// under the user's cache directory.
+func openDiskCache() (*diskCache, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cacheDir, "mudlark")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "cache.db"), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskCacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &diskCache{db: db}, nil
+}
+
+func (d *diskCache) close() error {
+	return d.db.Close()
+}
+
+func repoCacheKey(host, org, repo string) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%s", host, org, repo))
+}
+
+// get returns the persisted cache entry for a repo, or nil if nothing has
+// been cached for it yet.
+func (d *diskCache) get(host, org, repo string) (*persistedRepoCache, error) {
+	var result *persistedRepoCache
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(diskCacheBucket).Get(repoCacheKey(host, org, repo))
+		if data == nil {
+			return nil
+		}
+		decoded := &persistedRepoCache{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(decoded); err != nil {
+			return err
+		}
+		result = decoded
+		return nil
+	})
+	return result, err
+}
+
+func (d *diskCache) put(host, org, repo string, entry *persistedRepoCache) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskCacheBucket).Put(repoCacheKey(host, org, repo), buf.Bytes())
+	})
+}
+
+// mergePRCaches folds a sync result's changed pull/merge requests into the
+// previously persisted set, so a caller that only asked for what changed
+// since the last sync still ends up with every PR in the repo.
+func mergePRCaches(persisted *persistedRepoCache, sync *ForgeSyncResult) *persistedRepoCache {
+	result := &persistedRepoCache{
+		PullRequests: []*ForgePullRequest{},
+		Commits:      make(map[int][]*ForgeCommit),
+	}
+
+	seen := make(map[int]bool)
+	for _, pr := range sync.PullRequests {
+		result.PullRequests = append(result.PullRequests, pr)
+		result.Commits[pr.Number] = sync.Commits[pr.Number]
+		seen[pr.Number] = true
+	}
+
+	if persisted != nil {
+		for _, pr := range persisted.PullRequests {
+			if seen[pr.Number] {
+				continue
+			}
+			result.PullRequests = append(result.PullRequests, pr)
+			result.Commits[pr.Number] = persisted.Commits[pr.Number]
+		}
+	}
+
+	return result
+}
+
+// rateLimitBackoffThreshold is how much of a forge's API quota mudlark
+// insists on keeping in reserve. Below this it pauses until the quota
+// resets rather than risk tripping a hard rate limit mid-run.
+const rateLimitBackoffThreshold = 50
+
+// backoffForRateLimit checks a forge's remaining API quota and sleeps
+// until it resets if mudlark is close to exhausting it. Forges that don't
+// report a rate limit (RateLimit returning nil) are never throttled here.
+func backoffForRateLimit(ctx context.Context, forgeClient ForgeClient) error {
+	status, err := forgeClient.RateLimit(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not check rate limit")
+	}
+	if status == nil || status.Remaining > rateLimitBackoffThreshold {
+		return nil
+	}
+
+	wait := time.Until(status.ResetAt)
+	if wait <= 0 {
+		return nil
+	}
+	fmt.Printf("only %d API requests remaining, waiting %s for quota to reset\n",
+		status.Remaining, wait.Round(time.Second))
+	time.Sleep(wait)
+	return nil
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.gitea.io/sdk/gitea"
+	"github.com/google/go-github/v32/github"
+	"github.com/pkg/errors"
+	"github.com/xanzy/go-gitlab"
+)
+
+// ErrNotFound is returned by githubForgeClient.ListPullRequestsForCommit
+// when GitHub responds 404 for the repository, so callers can tell "not
+// forked downstream" apart from a transient API failure. The other GitHub
+// methods and the GitLab and Gitea clients return the forge's own error
+// for a missing repository or pull/merge request.
+var ErrNotFound = errors.New("not found")
+
+// Supported forge kinds are "github", "gitlab", and "gitea". Bitbucket is
+// out of scope for this ForgeClient registry: the spec this was built
+// against only asked for GitHub, GitLab (merge requests), and Gitea
+// backends, and the "list PRs that contain commit X" semantics needed for
+// cherry-pick matching haven't been worked out for Bitbucket's pull
+// request API. Adding it means a bitbucketForgeClient alongside the
+// existing three and a registry entry in parsePRURL's matchers.
+
+// ForgeRef identifies a single pull (or merge) request on a specific forge,
+// independent of which forge actually hosts it.
+type ForgeRef struct {
+	Kind string // "github", "gitlab", or "gitea"
+	Host string
+	Org  string
+	Repo string
+	ID   int
+}
+
+func (r ForgeRef) String() string {
+	return fmt.Sprintf("%s:%s/%s/%s#%d", r.Kind, r.Host, r.Org, r.Repo, r.ID)
+}
+
+// ForgePullRequest is the forge-agnostic view of a pull/merge request that
+// the rest of mudlark operates on.
+type ForgePullRequest struct {
+	Number   int
+	Title    string
+	State    string
+	HTMLURL  string
+	BaseRef  string
+	MergedAt *time.Time
+}
+
+// ForgeCommit is the forge-agnostic view of a single commit in a pull/merge
+// request.
+type ForgeCommit struct {
+	SHA     string
+	Message string
+}
+
+// ForgeClient is implemented once per forge (GitHub, GitLab, Gitea) so the
+// rest of mudlark can follow links and match commits without caring which
+// forge actually hosts the repository.
+type ForgeClient interface {
+	// GetPullRequest fetches a single pull/merge request.
+	GetPullRequest(ctx context.Context, org, repo string, id int) (*ForgePullRequest, error)
+	// ListPullRequestsForCommit returns the pull/merge requests that
+	// contain the given commit, as reported by the forge's API.
+	ListPullRequestsForCommit(ctx context.Context, org, repo, sha string) ([]*ForgePullRequest, error)
+	// ListCommits returns the commits that make up a pull/merge request.
+	ListCommits(ctx context.Context, org, repo string, id int) ([]*ForgeCommit, error)
+	// IsMerged reports whether a pull/merge request has been merged.
+	IsMerged(ctx context.Context, org, repo string, id int) (bool, error)
+	// ListAllPullRequests returns every pull/merge request in a repo
+	// together with its commits, for building the downstream cache.
+	ListAllPullRequests(ctx context.Context, org, repo string) ([]*ForgePullRequest, map[int][]*ForgeCommit, error)
+	// SyncPullRequests returns pull/merge requests updated since the
+	// caller's last sync, replaying etag as an If-None-Match validator
+	// where the forge supports one. Forges without ETag/since support
+	// fall back to a full ListAllPullRequests. Callers merge the result
+	// into whatever they already have cached.
+	SyncPullRequests(ctx context.Context, org, repo string, since time.Time, etag string) (*ForgeSyncResult, error)
+	// RateLimit reports the forge's remaining API quota, or nil if the
+	// forge doesn't expose one.
+	RateLimit(ctx context.Context) (*RateLimitStatus, error)
+	// CreatePullRequest opens a new pull/merge request from head (a
+	// branch name, or "org:branch" when head lives in a fork) into base.
+	CreatePullRequest(ctx context.Context, org, repo, title, head, base, body string) (*ForgePullRequest, error)
+}
+
+// ForgeSyncResult is the result of an incremental pull/merge request sync:
+// the PRs and commits changed since the caller's last sync, the
+// cache-validator to replay next time, and whether the forge reported no
+// changes at all.
+type ForgeSyncResult struct {
+	PullRequests []*ForgePullRequest
+	Commits      map[int][]*ForgeCommit
+	ETag         string
+	NotModified  bool
+}
+
+// RateLimitStatus is a forge's self-reported API quota.
+type RateLimitStatus struct {
+	Remaining int
+	Limit     int
+	ResetAt   time.Time
+}
+
+// forgeURLMatcher recognizes pull/merge request URLs for one forge kind and
+// extracts enough of a ForgeRef that the matching ForgeClient can be found.
+type forgeURLMatcher struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+var forgeURLMatchers = []forgeURLMatcher{
+	{
+		kind:    "github",
+		pattern: regexp.MustCompile(`https://(?P<host>github\.com)/(?P<org>[^/]+)/(?P<repo>[^/]+)/pull/(?P<id>\d+)`),
+	},
+	{
+		kind:    "gitlab",
+		pattern: regexp.MustCompile(`https://(?P<host>[^/]+)/(?P<org>[^/]+)/(?P<repo>[^/]+)/-/merge_requests/(?P<id>\d+)`),
+	},
+	{
+		kind:    "gitea",
+		pattern: regexp.MustCompile(`https://(?P<host>[^/]+)/(?P<org>[^/]+)/(?P<repo>[^/]+)/pulls/(?P<id>\d+)`),
+	},
+}
+
+// findForgeURLs scans text for every pull/merge request URL recognized by
+// any registered forge matcher, in the order they appear.
+func findForgeURLs(text string) []string {
+	type found struct {
+		start int
+		url   string
+	}
+	matches := []found{}
+	for _, matcher := range forgeURLMatchers {
+		for _, loc := range matcher.pattern.FindAllStringIndex(text, -1) {
+			matches = append(matches, found{start: loc[0], url: text[loc[0]:loc[1]]})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	results := make([]string, len(matches))
+	for i, m := range matches {
+		results[i] = m.url
+	}
+	return results
+}
+
+// parsePRURL parses a pull/merge request URL into a ForgeRef, trying each
+// registered forge's matcher in turn. The GitLab and Gitea matchers accept
+// any host and infer the kind from the URL's path alone, so the caller must
+// still confirm the host against the configured forges before using the
+// client for that kind.
+func parsePRURL(url string) (ForgeRef, error) {
+	for _, matcher := range forgeURLMatchers {
+		match := matcher.pattern.FindStringSubmatch(url)
+		if match == nil {
+			continue
+		}
+		ref := ForgeRef{Kind: matcher.kind}
+		for i, name := range matcher.pattern.SubexpNames() {
+			switch name {
+			case "host":
+				ref.Host = match[i]
+			case "org":
+				ref.Org = match[i]
+			case "repo":
+				ref.Repo = match[i]
+			case "id":
+				id, err := strconv.Atoi(match[i])
+				if err != nil {
+					return ForgeRef{}, errors.Wrap(err,
+						fmt.Sprintf("could not convert pull request id %q to integer", match[i]))
+				}
+				ref.ID = id
+			}
+		}
+		return ref, nil
+	}
+	return ForgeRef{}, fmt.Errorf("could not parse pull request URL %q", url)
+}
+
+// githubForgeClient adapts the go-github client to the ForgeClient
+// interface.
+type githubForgeClient struct {
+	client *github.Client
+}
+
+func newGithubForgeClient(client *github.Client) *githubForgeClient {
+	return &githubForgeClient{client: client}
+}
+
+func toForgePullRequest(pr *github.PullRequest) *ForgePullRequest {
+	return &ForgePullRequest{
+		Number:   *pr.Number,
+		Title:    *pr.Title,
+		State:    *pr.State,
+		HTMLURL:  *pr.HTMLURL,
+		BaseRef:  *pr.Base.Ref,
+		MergedAt: pr.MergedAt,
+	}
+}
+
+func (c *githubForgeClient) GetPullRequest(ctx context.Context, org, repo string, id int) (*ForgePullRequest, error) {
+	pr, _, err := c.client.PullRequests.Get(ctx, org, repo, id)
+	if err != nil {
+		return nil, err
+	}
+	return toForgePullRequest(pr), nil
+}
+
+func (c *githubForgeClient) ListPullRequestsForCommit(ctx context.Context, org, repo, sha string) ([]*ForgePullRequest, error) {
+	prs, response, err := c.client.PullRequests.ListPullRequestsWithCommit(ctx, org, repo, sha, nil)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	results := make([]*ForgePullRequest, len(prs))
+	for i, pr := range prs {
+		results[i] = toForgePullRequest(pr)
+	}
+	return results, nil
+}
+
+func (c *githubForgeClient) ListCommits(ctx context.Context, org, repo string, id int) ([]*ForgeCommit, error) {
+	commits, _, err := c.client.PullRequests.ListCommits(ctx, org, repo, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ForgeCommit, len(commits))
+	for i, commit := range commits {
+		results[i] = &ForgeCommit{SHA: *commit.SHA, Message: *commit.Commit.Message}
+	}
+	return results, nil
+}
+
+func (c *githubForgeClient) IsMerged(ctx context.Context, org, repo string, id int) (bool, error) {
+	isMerged, _, err := c.client.PullRequests.IsMerged(ctx, org, repo, id)
+	return isMerged, err
+}
+
+func (c *githubForgeClient) ListAllPullRequests(ctx context.Context, org, repo string) ([]*ForgePullRequest, map[int][]*ForgeCommit, error) {
+	pullRequests := []*ForgePullRequest{}
+	commits := make(map[int][]*ForgeCommit)
+
+	opts := &github.PullRequestListOptions{
+		State: "all",
+		ListOptions: github.ListOptions{
+			PerPage: githubPageSize,
+		},
+	}
+
+	for {
+		prs, response, err := c.client.PullRequests.List(ctx, org, repo, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pr := range prs {
+			pullRequests = append(pullRequests, toForgePullRequest(pr))
+			prCommits, err := c.ListCommits(ctx, org, repo, *pr.Number)
+			if err != nil {
+				return nil, nil, err
+			}
+			commits[*pr.Number] = prCommits
+		}
+
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+
+	return pullRequests, commits, nil
+}
+
+// SyncPullRequests asks GitHub for pull requests sorted newest-updated
+// first, replaying etag as If-None-Match on the first page so an
+// unchanged repo costs a single request that doesn't count against the
+// rate limit. Since the list isn't filterable by update time directly, it
+// pages through exactly like ListAllPullRequests, stopping only once it
+// reaches a pull request updated before since (or runs out of pages) --
+// on a cold cache, since is the zero time and this walks every PR.
+func (c *githubForgeClient) SyncPullRequests(ctx context.Context, org, repo string, since time.Time, etag string) (*ForgeSyncResult, error) {
+	pullRequests := []*ForgePullRequest{}
+	commits := make(map[int][]*ForgeCommit)
+	newETag := etag
+
+	page := 1
+	for {
+		path := fmt.Sprintf("repos/%s/%s/pulls?state=all&sort=updated&direction=desc&per_page=%d&page=%d",
+			org, repo, githubPageSize, page)
+		req, err := c.client.NewRequest("GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if page == 1 && etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		var prs []*github.PullRequest
+		response, err := c.client.Do(ctx, req, &prs)
+		if page == 1 && response != nil && response.StatusCode == http.StatusNotModified {
+			return &ForgeSyncResult{ETag: etag, NotModified: true}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if page == 1 {
+			newETag = response.Header.Get("ETag")
+		}
+
+		reachedSince := false
+		for _, pr := range prs {
+			if pr.UpdatedAt != nil && pr.UpdatedAt.Before(since) {
+				reachedSince = true
+				break
+			}
+			forgePR := toForgePullRequest(pr)
+			pullRequests = append(pullRequests, forgePR)
+			prCommits, err := c.ListCommits(ctx, org, repo, forgePR.Number)
+			if err != nil {
+				return nil, err
+			}
+			commits[forgePR.Number] = prCommits
+		}
+
+		if reachedSince || response.NextPage == 0 {
+			break
+		}
+		page = response.NextPage
+	}
+
+	return &ForgeSyncResult{
+		PullRequests: pullRequests,
+		Commits:      commits,
+		ETag:         newETag,
+	}, nil
+}
+
+// RateLimit reports GitHub's self-service rate limit status for the
+// credential this client is using.
+func (c *githubForgeClient) RateLimit(ctx context.Context) (*RateLimitStatus, error) {
+	limits, _, err := c.client.RateLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimitStatus{
+		Remaining: limits.Core.Remaining,
+		Limit:     limits.Core.Limit,
+		ResetAt:   limits.Core.Reset.Time,
+	}, nil
+}
+
+// CreatePullRequest opens a pull request on GitHub. head may be a plain
+// branch name or "org:branch" when the branch lives in a fork.
+func (c *githubForgeClient) CreatePullRequest(ctx context.Context, org, repo, title, head, base, body string) (*ForgePullRequest, error) {
+	pr, _, err := c.client.PullRequests.Create(ctx, org, repo, &github.NewPullRequest{
+		Title: &title,
+		Head:  &head,
+		Base:  &base,
+		Body:  &body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toForgePullRequest(pr), nil
+}
+
+// newForgeClient builds the ForgeClient for one configured forge.
+func newForgeClient(cfg forgeSettings) (ForgeClient, error) {
+	switch cfg.Type {
+	case "github":
+		var credential Credential
+		var err error
+		if cfg.GithubApp != nil {
+			credential, err = newGithubAppCredential(cfg.GithubApp)
+		} else {
+			credential = &tokenCredential{Token: cfg.Token}
+		}
+		if err != nil {
+			return nil, err
+		}
+		httpClient := (&credentialTransport{credential: credential}).client()
+		return newGithubForgeClient(github.NewClient(httpClient)), nil
+	case "gitlab":
+		client, err := gitlab.NewClient(cfg.Token, gitlab.WithBaseURL(fmt.Sprintf("https://%s", cfg.Host)))
+		if err != nil {
+			return nil, err
+		}
+		return newGitlabForgeClient(client), nil
+	case "gitea":
+		client, err := gitea.NewClient(fmt.Sprintf("https://%s", cfg.Host), gitea.SetToken(cfg.Token))
+		if err != nil {
+			return nil, err
+		}
+		return newGiteaForgeClient(client), nil
+	default:
+		return nil, fmt.Errorf("unknown forge type %q", cfg.Type)
+	}
+}
+
+// buildForgeClients constructs a ForgeClient for every forge in settings,
+// keyed by host.
+func buildForgeClients(settings *appSettings) (map[string]ForgeClient, error) {
+	clients := make(map[string]ForgeClient)
+	for _, cfg := range settings.Forges {
+		client, err := newForgeClient(cfg)
+		if err != nil {
+			return nil, errors.Wrap(err,
+				fmt.Sprintf("could not create client for forge %q", cfg.Host))
+		}
+		clients[cfg.Host] = client
+	}
+	return clients, nil
+}
+
+// gitlabForgeClient adapts the go-gitlab client to the ForgeClient
+// interface. GitLab calls pull requests "merge requests"; mudlark keeps
+// calling them pull requests everywhere outside this file.
+type gitlabForgeClient struct {
+	client *gitlab.Client
+}
+
+func newGitlabForgeClient(client *gitlab.Client) *gitlabForgeClient {
+	return &gitlabForgeClient{client: client}
+}
+
+func projectID(org, repo string) string {
+	return fmt.Sprintf("%s/%s", org, repo)
+}
+
+func toForgeMergeRequest(mr *gitlab.MergeRequest) *ForgePullRequest {
+	return &ForgePullRequest{
+		Number:   mr.IID,
+		Title:    mr.Title,
+		State:    mr.State,
+		HTMLURL:  mr.WebURL,
+		BaseRef:  mr.TargetBranch,
+		MergedAt: mr.MergedAt,
+	}
+}
+
+func (c *gitlabForgeClient) GetPullRequest(ctx context.Context, org, repo string, id int) (*ForgePullRequest, error) {
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(projectID(org, repo), id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toForgeMergeRequest(mr), nil
+}
+
+func (c *gitlabForgeClient) ListPullRequestsForCommit(ctx context.Context, org, repo, sha string) ([]*ForgePullRequest, error) {
+	mrs, _, err := c.client.Commits.ListMergeRequestsByCommit(projectID(org, repo), sha)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ForgePullRequest, len(mrs))
+	for i, mr := range mrs {
+		results[i] = &ForgePullRequest{
+			Number:   mr.IID,
+			Title:    mr.Title,
+			State:    mr.State,
+			HTMLURL:  mr.WebURL,
+			BaseRef:  mr.TargetBranch,
+			MergedAt: mr.MergedAt,
+		}
+	}
+	return results, nil
+}
+
+func (c *gitlabForgeClient) ListCommits(ctx context.Context, org, repo string, id int) ([]*ForgeCommit, error) {
+	commits, _, err := c.client.MergeRequests.GetMergeRequestCommits(projectID(org, repo), id, nil)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ForgeCommit, len(commits))
+	for i, commit := range commits {
+		results[i] = &ForgeCommit{SHA: commit.ID, Message: commit.Message}
+	}
+	return results, nil
+}
+
+func (c *gitlabForgeClient) IsMerged(ctx context.Context, org, repo string, id int) (bool, error) {
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(projectID(org, repo), id, nil)
+	if err != nil {
+		return false, err
+	}
+	return mr.State == "merged", nil
+}
+
+func (c *gitlabForgeClient) ListAllPullRequests(ctx context.Context, org, repo string) ([]*ForgePullRequest, map[int][]*ForgeCommit, error) {
+	pullRequests := []*ForgePullRequest{}
+	commits := make(map[int][]*ForgeCommit)
+
+	opts := &gitlab.ListProjectMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: githubPageSize,
+		},
+	}
+
+	for {
+		mrs, response, err := c.client.MergeRequests.ListProjectMergeRequests(projectID(org, repo), opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, mr := range mrs {
+			pullRequests = append(pullRequests, toForgeMergeRequest(mr))
+			mrCommits, err := c.ListCommits(ctx, org, repo, mr.IID)
+			if err != nil {
+				return nil, nil, err
+			}
+			commits[mr.IID] = mrCommits
+		}
+
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+
+	return pullRequests, commits, nil
+}
+
+// SyncPullRequests falls back to a full ListAllPullRequests: GitLab's merge
+// request list doesn't support conditional requests, so there's no ETag to
+// replay and since is unused.
+func (c *gitlabForgeClient) SyncPullRequests(ctx context.Context, org, repo string, since time.Time, etag string) (*ForgeSyncResult, error) {
+	pullRequests, commits, err := c.ListAllPullRequests(ctx, org, repo)
+	if err != nil {
+		return nil, err
+	}
+	return &ForgeSyncResult{PullRequests: pullRequests, Commits: commits}, nil
+}
+
+// RateLimit always returns nil: GitLab's rate limit headers aren't wired
+// up here, so mudlark never throttles itself against this forge.
+func (c *gitlabForgeClient) RateLimit(ctx context.Context) (*RateLimitStatus, error) {
+	return nil, nil
+}
+
+// CreatePullRequest opens a merge request. head is either a bare branch
+// name (the common case, when org/repo is both where the branch lives and
+// where the MR should land) or "forkOrg:branch" when the branch was
+// pushed to a separate fork project. GitLab has no notion of opening a
+// merge request "from" another project without one, so a forked head
+// means posting the create call to the fork project itself with
+// TargetProjectID set to the numeric ID of org/repo.
+func (c *gitlabForgeClient) CreatePullRequest(ctx context.Context, org, repo, title, head, base, body string) (*ForgePullRequest, error) {
+	sourceProject := projectID(org, repo)
+	branch := head
+
+	opts := &gitlab.CreateMergeRequestOptions{
+		Title:        &title,
+		SourceBranch: &branch,
+		TargetBranch: &base,
+		Description:  &body,
+	}
+
+	if idx := strings.LastIndex(head, ":"); idx != -1 {
+		forkOrg := head[:idx]
+		branch = head[idx+1:]
+		sourceProject = projectID(forkOrg, repo)
+		opts.SourceBranch = &branch
+
+		targetProject, _, err := c.client.Projects.GetProject(projectID(org, repo), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not look up downstream project %s/%s", org, repo))
+		}
+		opts.TargetProjectID = &targetProject.ID
+	}
+
+	mr, _, err := c.client.MergeRequests.CreateMergeRequest(sourceProject, opts)
+	if err != nil {
+		return nil, err
+	}
+	return toForgeMergeRequest(mr), nil
+}
+
+// giteaForgeClient adapts the Gitea SDK client to the ForgeClient
+// interface.
+type giteaForgeClient struct {
+	client *gitea.Client
+}
+
+func newGiteaForgeClient(client *gitea.Client) *giteaForgeClient {
+	return &giteaForgeClient{client: client}
+}
+
+func toForgePullRequestFromGitea(pr *gitea.PullRequest) *ForgePullRequest {
+	result := &ForgePullRequest{
+		Number:   int(pr.Index),
+		Title:    pr.Title,
+		State:    string(pr.State),
+		HTMLURL:  pr.HTMLURL,
+		MergedAt: pr.Merged,
+	}
+	if pr.Base != nil {
+		result.BaseRef = pr.Base.Ref
+	}
+	return result
+}
+
+func (c *giteaForgeClient) GetPullRequest(ctx context.Context, org, repo string, id int) (*ForgePullRequest, error) {
+	pr, _, err := c.client.GetPullRequest(org, repo, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	return toForgePullRequestFromGitea(pr), nil
+}
+
+func (c *giteaForgeClient) ListPullRequestsForCommit(ctx context.Context, org, repo, sha string) ([]*ForgePullRequest, error) {
+	// The Gitea API does not expose "list pull requests containing
+	// commit X" directly, so fall back to scanning every pull request's
+	// commits; callers hit this path once per upstream commit.
+	allPRs, allCommits, err := c.ListAllPullRequests(ctx, org, repo)
+	if err != nil {
+		return nil, err
+	}
+	results := []*ForgePullRequest{}
+	for _, pr := range allPRs {
+		for _, commit := range allCommits[pr.Number] {
+			if commit.SHA == sha {
+				results = append(results, pr)
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+func (c *giteaForgeClient) ListCommits(ctx context.Context, org, repo string, id int) ([]*ForgeCommit, error) {
+	commits, _, err := c.client.ListPullRequestCommits(org, repo, int64(id), gitea.ListPullRequestCommitsOptions{})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ForgeCommit, len(commits))
+	for i, commit := range commits {
+		results[i] = &ForgeCommit{SHA: commit.SHA, Message: commit.RepoCommit.Message}
+	}
+	return results, nil
+}
+
+func (c *giteaForgeClient) IsMerged(ctx context.Context, org, repo string, id int) (bool, error) {
+	isMerged, _, err := c.client.IsPullRequestMerged(org, repo, int64(id))
+	return isMerged, err
+}
+
+func (c *giteaForgeClient) ListAllPullRequests(ctx context.Context, org, repo string) ([]*ForgePullRequest, map[int][]*ForgeCommit, error) {
+	pullRequests := []*ForgePullRequest{}
+	commits := make(map[int][]*ForgeCommit)
+
+	opts := gitea.ListPullRequestsOptions{
+		State: gitea.StateAll,
+		ListOptions: gitea.ListOptions{
+			PageSize: githubPageSize,
+		},
+	}
+
+	for {
+		prs, response, err := c.client.ListRepoPullRequests(org, repo, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, pr := range prs {
+			forgePR := toForgePullRequestFromGitea(pr)
+			pullRequests = append(pullRequests, forgePR)
+			prCommits, err := c.ListCommits(ctx, org, repo, forgePR.Number)
+			if err != nil {
+				return nil, nil, err
+			}
+			commits[forgePR.Number] = prCommits
+		}
+
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+
+	return pullRequests, commits, nil
+}
+
+// SyncPullRequests falls back to a full ListAllPullRequests: the Gitea API
+// mudlark talks to here doesn't expose ETags on the list endpoint, so
+// there's nothing to replay and since is unused.
+func (c *giteaForgeClient) SyncPullRequests(ctx context.Context, org, repo string, since time.Time, etag string) (*ForgeSyncResult, error) {
+	pullRequests, commits, err := c.ListAllPullRequests(ctx, org, repo)
+	if err != nil {
+		return nil, err
+	}
+	return &ForgeSyncResult{PullRequests: pullRequests, Commits: commits}, nil
+}
+
+// RateLimit always returns nil: this Gitea client doesn't surface quota
+// headers, so mudlark never throttles itself against this forge.
+func (c *giteaForgeClient) RateLimit(ctx context.Context) (*RateLimitStatus, error) {
+	return nil, nil
+}
+
+// CreatePullRequest opens a pull request on Gitea. head may be a plain
+// branch name or "org:branch" when the branch lives in a fork.
+func (c *giteaForgeClient) CreatePullRequest(ctx context.Context, org, repo, title, head, base, body string) (*ForgePullRequest, error) {
+	pr, _, err := c.client.CreatePullRequest(org, repo, gitea.CreatePullRequestOption{
+		Title: title,
+		Head:  head,
+		Base:  base,
+		Body:  body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toForgePullRequestFromGitea(pr), nil
+}
@@ -5,80 +5,143 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/andygrunwald/go-jira"
-	"github.com/google/go-github/v32/github"
 	"github.com/pkg/errors"
-	"golang.org/x/oauth2"
 	"gopkg.in/yaml.v2"
 )
 
-var pullRequestURLPattern *regexp.Regexp
-
-func init() {
-	pullRequestURLPattern = regexp.MustCompile("https://github.com/(?P<org>[^/]+)/(?P<repo>[^/]+)/pull/(?P<id>\\d+)")
+// jiraOAuth1Settings configures Atlassian's OAuth 1.0a signed-request
+// flow: a consumer key registered with the JIRA instance, the matching
+// RSA private key, and where to persist the access token once
+// `mudlark -jira-authorize` has obtained one.
+type jiraOAuth1Settings struct {
+	ConsumerKey    string `yaml:"consumerKey"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	TokenFile      string `yaml:"tokenFile"`
 }
 
+// jiraSettings accepts exactly one authentication variant: basic auth
+// (User/Password), a personal access token (Token), a session cookie
+// obtained by logging in with SessionUser/SessionPassword, or OAuth1.
+// loadSettings rejects configurations with zero or more than one set.
 type jiraSettings struct {
+	URL string `yaml:"url"`
+
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
-	URL      string `yaml:"url"`
-}
 
-type githubSettings struct {
 	Token string `yaml:"token"`
+
+	SessionUser     string `yaml:"sessionUser"`
+	SessionPassword string `yaml:"sessionPassword"`
+
+	OAuth1 *jiraOAuth1Settings `yaml:"oauth1"`
+}
+
+// githubAppSettings authenticates as a GitHub App installation instead of
+// with a personal access token, exchanging a private-key-signed JWT for a
+// short-lived installation token on demand. Useful for long-running uses
+// of mudlark where a human token owner isn't appropriate.
+type githubAppSettings struct {
+	AppID          string `yaml:"appId"`
+	InstallationID string `yaml:"installationId"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+}
+
+// forgeSettings configures a single forge that mudlark should know how to
+// talk to. The downstream org for that forge lives here too, since a team
+// can mirror upstream into a different org per forge -- or, via
+// DownstreamHost, into an org on a different forge entirely (e.g. an
+// upstream GitHub project mirrored into an internal Gitea). DownstreamHost
+// must itself appear as the Host of another entry in appSettings.Forges,
+// so there's a ForgeClient to talk to it with; it defaults to Host, for
+// the common case of mirroring within the same forge. Github forges
+// authenticate with either Token or GithubApp, never both; GitLab and
+// Gitea forges always use Token.
+type forgeSettings struct {
+	Type           string             `yaml:"type"` // "github", "gitlab", or "gitea"
+	Host           string             `yaml:"host"`
+	Token          string             `yaml:"token"`
+	GithubApp      *githubAppSettings `yaml:"githubApp"`
+	DownstreamOrg  string             `yaml:"downstreamOrg"`
+	DownstreamHost string             `yaml:"downstreamHost"`
+}
+
+// downstreamHost returns the host that this forge's downstream fork lives
+// on, defaulting to the upstream forge's own host.
+func (f *forgeSettings) downstreamHost() string {
+	if f.DownstreamHost != "" {
+		return f.DownstreamHost
+	}
+	return f.Host
 }
 
 type appSettings struct {
-	Jira          jiraSettings   `yaml:"jira"`
-	Github        githubSettings `yaml:"github"`
-	DownstreamOrg string         `yaml:"downstreamOrg"`
-	verbose       bool
+	Jira     jiraSettings      `yaml:"jira"`
+	Forges   []forgeSettings   `yaml:"forges"`
+	Backport *backportSettings `yaml:"backport"`
+	verbose  bool
+}
+
+// forgeFor returns the configuration for the forge hosted at host, or nil
+// if none is configured.
+func (s *appSettings) forgeFor(host string) *forgeSettings {
+	for i := range s.Forges {
+		if s.Forges[i].Host == host {
+			return &s.Forges[i]
+		}
+	}
+	return nil
 }
 
 type serviceClients struct {
 	jira   *jira.Client
-	github *github.Client
+	forges map[string]ForgeClient // keyed by forgeSettings.Host
 }
 
 type repoPRCache struct {
-	pullRequests []*github.PullRequest
-	commits      map[int][]*github.RepositoryCommit
+	pullRequests []*ForgePullRequest
+	commits      map[int][]*ForgeCommit
 }
 
 type cache struct {
 	pullRequestsByRepo map[string]repoPRCache
+	disk               *diskCache
 	mutex              sync.Mutex
 }
 
 type pullRequestWithStatus struct {
-	pull   *github.PullRequest
+	pull   *ForgePullRequest
 	status string
 }
 
 func (pr pullRequestWithStatus) String() string {
 	return fmt.Sprintf("on %s %s: %s \"%s\"",
-		*pr.pull.Base.Ref,
+		pr.pull.BaseRef,
 		pr.status,
-		*pr.pull.HTMLURL,
-		*pr.pull.Title,
+		pr.pull.HTMLURL,
+		pr.pull.Title,
 	)
 }
 
 type linkResult struct {
 	url          string
-	org          string
-	repo         string
-	id           int
+	ref          ForgeRef
 	prWithStatus pullRequestWithStatus
 	others       []*linkResult
+	// matchReason explains how this result was found as a downstream
+	// match for its parent: "via SHA" (the forge's own commit/PR lookup,
+	// or a literal SHA in another PR's commit messages), "via trailer"
+	// (a cherry-pick/backport trailer naming the upstream SHA), or "via
+	// jira key" (a Jira/Resolves/Fixes trailer naming the same issue).
+	// Empty for top-level links, which aren't a match for anything.
+	matchReason string
 }
 
 type issueResult struct {
@@ -89,53 +152,69 @@ type issueResult struct {
 
 const githubPageSize int = 50
 
-func (c *cache) getDetails(settings *appSettings, clients *serviceClients, org, repo string) (*repoPRCache, error) {
+func (c *cache) getDetails(clients *serviceClients, host, org, repo string) (*repoPRCache, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	ctx := context.Background()
-	repoKey := fmt.Sprintf("%s/%s", org, repo)
-	prCache, ok := c.pullRequestsByRepo[repoKey]
+	repoKey := fmt.Sprintf("%s/%s/%s", host, org, repo)
+	if prCache, ok := c.pullRequestsByRepo[repoKey]; ok {
+		return &prCache, nil
+	}
 
+	forgeClient, ok := clients.forges[host]
 	if !ok {
-		prCache = repoPRCache{
-			pullRequests: []*github.PullRequest{},
-			commits:      make(map[int][]*github.RepositoryCommit),
-		}
-		c.pullRequestsByRepo[repoKey] = prCache
+		return nil, fmt.Errorf("no forge configured for host %q", host)
+	}
 
-		opts := &github.PullRequestListOptions{
-			State: "all",
-			ListOptions: github.ListOptions{
-				PerPage: githubPageSize,
-			},
+	var persisted *persistedRepoCache
+	if c.disk != nil {
+		var err error
+		persisted, err = c.disk.get(host, org, repo)
+		if err != nil {
+			return nil, errors.Wrap(err,
+				fmt.Sprintf("could not read disk cache for %s", repoKey))
 		}
+	}
 
-		fmt.Printf("building cache of PRs for %s/%s\n", org, repo)
-		for {
-			prs, response, err := clients.github.PullRequests.List(ctx, org, repo, opts)
-			if err != nil {
-				return nil, errors.Wrap(err,
-					fmt.Sprintf("could not get pull requests for %s", repoKey))
-			}
-			prCache.pullRequests = append(prCache.pullRequests, prs...)
-
-			for _, pr := range prs {
-				commits, _, err := clients.github.PullRequests.ListCommits(
-					ctx, org, repo, *pr.Number, nil)
-				if err != nil {
-					return nil, errors.Wrap(err,
-						fmt.Sprintf("could not get commits for pull request %d", *pr.Number))
-				}
-				prCache.commits[*pr.Number] = commits
-			}
+	if err := backoffForRateLimit(ctx, forgeClient); err != nil {
+		return nil, err
+	}
 
-			if response.NextPage == 0 {
-				break
+	var since time.Time
+	var etag string
+	if persisted != nil {
+		since = persisted.SyncedAt
+		etag = persisted.ETag
+	}
+
+	fmt.Printf("syncing PRs for %s (since %s)\n", repoKey, since.Format(time.RFC3339))
+	sync, err := forgeClient.SyncPullRequests(ctx, org, repo, since, etag)
+	if err != nil {
+		return nil, errors.Wrap(err,
+			fmt.Sprintf("could not sync pull requests for %s", repoKey))
+	}
+
+	var merged *persistedRepoCache
+	if sync.NotModified && persisted != nil {
+		merged = persisted
+	} else {
+		merged = mergePRCaches(persisted, sync)
+		merged.ETag = sync.ETag
+		merged.SyncedAt = time.Now()
+		if c.disk != nil {
+			if err := c.disk.put(host, org, repo, merged); err != nil {
+				return nil, errors.Wrap(err,
+					fmt.Sprintf("could not write disk cache for %s", repoKey))
 			}
-			opts.Page = response.NextPage
 		}
 	}
+
+	prCache := repoPRCache{
+		pullRequests: merged.PullRequests,
+		commits:      merged.Commits,
+	}
+	c.pullRequestsByRepo[repoKey] = prCache
 	return &prCache, nil
 }
 
@@ -155,24 +234,83 @@ func loadSettings(filename string) (*appSettings, error) {
 	if result.Jira.URL == "" {
 		return nil, fmt.Errorf("No jira.url found in %s", filename)
 	}
-	if result.Jira.User == "" {
-		return nil, fmt.Errorf("No jira.user found in %s", filename)
-	}
-	if result.Jira.Password == "" {
-		return nil, fmt.Errorf("No jira.password found in %s", filename)
+	if err := validateJiraCredentialSettings(&result.Jira, filename); err != nil {
+		return nil, err
 	}
 
-	if result.Github.Token == "" {
-		return nil, fmt.Errorf("No github.token found in %s", filename)
+	if len(result.Forges) == 0 {
+		return nil, fmt.Errorf("No forges found in %s", filename)
 	}
-
-	if result.DownstreamOrg == "" {
-		return nil, fmt.Errorf("No downstreamOrg found in %s", filename)
+	for _, forge := range result.Forges {
+		switch forge.Type {
+		case "github", "gitlab", "gitea":
+		default:
+			return nil, fmt.Errorf("Unknown forge type %q in %s", forge.Type, filename)
+		}
+		if forge.Host == "" {
+			return nil, fmt.Errorf("No host found for a forge in %s", filename)
+		}
+		if forge.Type == "github" && forge.GithubApp != nil {
+			if forge.Token != "" {
+				return nil, fmt.Errorf("Forge %q in %s sets both token and githubApp; use only one", forge.Host, filename)
+			}
+			if forge.GithubApp.AppID == "" || forge.GithubApp.InstallationID == "" || forge.GithubApp.PrivateKeyFile == "" {
+				return nil, fmt.Errorf("Forge %q in %s has an incomplete githubApp block", forge.Host, filename)
+			}
+		} else if forge.Token == "" {
+			return nil, fmt.Errorf("No token found for forge %q in %s", forge.Host, filename)
+		}
+		if forge.DownstreamOrg == "" {
+			return nil, fmt.Errorf("No downstreamOrg found for forge %q in %s", forge.Host, filename)
+		}
+	}
+	for _, forge := range result.Forges {
+		if result.forgeFor(forge.downstreamHost()) == nil {
+			return nil, fmt.Errorf("forge %q sets downstreamHost %q, but no forge with that host is configured in %s",
+				forge.Host, forge.downstreamHost(), filename)
+		}
 	}
 
 	return &result, nil
 }
 
+// validateJiraCredentialSettings checks that exactly one of the jira:
+// block's authentication variants is configured.
+func validateJiraCredentialSettings(settings *jiraSettings, filename string) error {
+	configured := 0
+	if settings.User != "" || settings.Password != "" {
+		if settings.User == "" || settings.Password == "" {
+			return fmt.Errorf("jira.user and jira.password must both be set in %s", filename)
+		}
+		configured++
+	}
+	if settings.Token != "" {
+		configured++
+	}
+	if settings.SessionUser != "" || settings.SessionPassword != "" {
+		if settings.SessionUser == "" || settings.SessionPassword == "" {
+			return fmt.Errorf("jira.sessionUser and jira.sessionPassword must both be set in %s", filename)
+		}
+		configured++
+	}
+	if settings.OAuth1 != nil {
+		if settings.OAuth1.ConsumerKey == "" || settings.OAuth1.PrivateKeyFile == "" {
+			return fmt.Errorf("jira.oauth1 is missing consumerKey or privateKeyFile in %s", filename)
+		}
+		if settings.OAuth1.TokenFile == "" {
+			settings.OAuth1.TokenFile = filepath.Join(filepath.Dir(filename), "jira-oauth1-token.json")
+		}
+		configured++
+	}
+	if configured == 0 {
+		return fmt.Errorf("No jira credential (user/password, token, sessionUser/sessionPassword, or oauth1) found in %s", filename)
+	}
+	if configured > 1 {
+		return fmt.Errorf("Only one jira credential variant may be configured in %s", filename)
+	}
+	return nil
+}
+
 func issueTitleLine(issue *jira.Issue, jiraURL string) string {
 	return fmt.Sprintf("%s (%s) %s/browse/%s %q",
 		issue.Fields.Type.Name,
@@ -200,28 +338,27 @@ func getLinks(issue *jira.Issue) []string {
 	results := []string{}
 
 	results = append(results,
-		pullRequestURLPattern.FindAllString(issue.Fields.Description, -1)...)
+		findForgeURLs(issue.Fields.Description)...)
 
 	if issue.Fields.Comments != nil {
 		for _, comment := range issue.Fields.Comments.Comments {
 			results = append(results,
-				pullRequestURLPattern.FindAllString(comment.Body, -1)...)
+				findForgeURLs(comment.Body)...)
 		}
 	}
 
 	return results
 }
 
-func getPRStatus(settings *appSettings, clients *serviceClients, pullRequest *github.PullRequest) (pullRequestWithStatus, error) {
+func getPRStatus(clients *serviceClients, ref ForgeRef, pullRequest *ForgePullRequest) (pullRequestWithStatus, error) {
 	result := pullRequestWithStatus{pull: pullRequest}
 	ctx := context.Background()
-	result.status = *pullRequest.State
-	isMerged, _, err := clients.github.PullRequests.IsMerged(ctx,
-		*pullRequest.Base.Repo.Owner.Login, *pullRequest.Base.Repo.Name, *pullRequest.Number)
+	result.status = pullRequest.State
+	forgeClient := clients.forges[ref.Host]
+	isMerged, err := forgeClient.IsMerged(ctx, ref.Org, ref.Repo, ref.ID)
 	if err != nil {
 		return result, errors.Wrap(err,
-			fmt.Sprintf("could not fetch merge status of pull request %d",
-				*pullRequest.Number))
+			fmt.Sprintf("could not fetch merge status of pull request %d", ref.ID))
 	}
 	if isMerged {
 		result.status = "merged"
@@ -232,20 +369,7 @@ func getPRStatus(settings *appSettings, clients *serviceClients, pullRequest *gi
 	return result, nil
 }
 
-func parsePRURL(url string) (org, repo string, id int, err error) {
-	match := pullRequestURLPattern.FindStringSubmatch(url)
-	org = match[1]
-	repo = match[2]
-	idStr := match[3]
-	id, err = strconv.Atoi(idStr)
-	if err != nil {
-		err = errors.Wrap(err,
-			fmt.Sprintf("could not convert pull request id %q to integer", idStr))
-	}
-	return
-}
-
-func processOneLink(settings *appSettings, clients *serviceClients, cache *cache, url string) (*linkResult, error) {
+func processOneLink(settings *appSettings, clients *serviceClients, cache *cache, issueKey, url string) (*linkResult, error) {
 	if settings.verbose {
 		fmt.Fprintf(os.Stderr, "getting details for %s\n", url)
 	}
@@ -255,32 +379,40 @@ func processOneLink(settings *appSettings, clients *serviceClients, cache *cache
 	}
 	ctx := context.Background()
 
-	// parse the URL to find the args we need for interacting with
-	// github's API
-	org, repo, id, err := parsePRURL(url)
+	// parse the URL to find the args we need for interacting with the
+	// forge's API
+	ref, err := parsePRURL(url)
 	if err != nil {
 		return nil, errors.Wrap(err,
 			fmt.Sprintf("could not parse pull request URL %q", url))
 	}
-	result.org = org
-	result.repo = repo
-	result.id = id
+	result.ref = ref
 
-	pullRequest, _, err := clients.github.PullRequests.Get(ctx,
-		result.org, result.repo, result.id)
+	forgeClient, ok := clients.forges[ref.Host]
+	if !ok {
+		return nil, fmt.Errorf("no forge configured for host %q (from %s)", ref.Host, url)
+	}
+	forgeCfg := settings.forgeFor(ref.Host)
+	downstreamHost := forgeCfg.downstreamHost()
+	downstreamClient, ok := clients.forges[downstreamHost]
+	if !ok {
+		return nil, fmt.Errorf("no forge configured for downstream host %q (from %s)", downstreamHost, url)
+	}
+
+	pullRequest, err := forgeClient.GetPullRequest(ctx, ref.Org, ref.Repo, ref.ID)
 	if err != nil {
 		return nil, errors.Wrap(err,
 			fmt.Sprintf("could not fetch pull request %q", url))
 	}
 
-	prWithStatus, err := getPRStatus(settings, clients, pullRequest)
+	prWithStatus, err := getPRStatus(clients, ref, pullRequest)
 	if err != nil {
 		return nil, errors.Wrap(err,
-			fmt.Sprintf("could not get status of %s", *pullRequest.HTMLURL))
+			fmt.Sprintf("could not get status of %s", pullRequest.HTMLURL))
 	}
 	result.prWithStatus = prWithStatus
 
-	if result.org == settings.DownstreamOrg {
+	if ref.Host == downstreamHost && ref.Org == forgeCfg.DownstreamOrg {
 		return result, nil
 	}
 
@@ -290,8 +422,7 @@ func processOneLink(settings *appSettings, clients *serviceClients, cache *cache
 		return result, nil
 	}
 
-	commits, _, err := clients.github.PullRequests.ListCommits(
-		ctx, result.org, result.repo, result.id, nil)
+	commits, err := forgeClient.ListCommits(ctx, ref.Org, ref.Repo, ref.ID)
 	if err != nil {
 		return nil, errors.Wrap(err,
 			fmt.Sprintf("could not list commits in pull request %q", url))
@@ -299,75 +430,127 @@ func processOneLink(settings *appSettings, clients *serviceClients, cache *cache
 
 	otherIDs := make(map[int]bool)
 	otherLinks := []string{}
+	otherReasons := make(map[string]string)
+	downstreamRepoMissing := false
+
 	for _, c := range commits {
 
 		// look for pull requests containing the same commits via
-		// the github API
-		otherPRs, response, err := clients.github.PullRequests.ListPullRequestsWithCommit(
-			ctx, settings.DownstreamOrg, result.repo, *c.SHA, nil)
+		// the downstream forge's API
+		otherPRs, err := downstreamClient.ListPullRequestsForCommit(
+			ctx, forgeCfg.DownstreamOrg, ref.Repo, c.SHA)
 		if err != nil {
-			if response.StatusCode == http.StatusNotFound {
+			if err == ErrNotFound {
 				// The repository hasn't been forked downstream. Treat
 				// it as not an error and break out of this loop.
 				if settings.verbose {
 					fmt.Fprintf(os.Stderr, "no downstream repository %s/%s, skipping\n",
-						settings.DownstreamOrg, result.repo)
+						forgeCfg.DownstreamOrg, ref.Repo)
 				}
+				downstreamRepoMissing = true
 				break
 			}
 			return nil, errors.Wrap(err, "could not find downstream pull requests")
 		}
 
 		for _, otherPR := range otherPRs {
-			if *otherPR.HTMLURL == url {
+			if otherPR.HTMLURL == url {
 				// the API returns our own PR even when we ask
 				// for the ones from the downstream PR
 				continue
 			}
-			if _, ok := otherIDs[*otherPR.Number]; ok {
+			if _, ok := otherIDs[otherPR.Number]; ok {
 				// ignore duplicate PRs
 				continue
 			}
-			otherIDs[*otherPR.Number] = true
-			otherLinks = append(otherLinks, *otherPR.HTMLURL)
+			otherIDs[otherPR.Number] = true
+			otherLinks = append(otherLinks, otherPR.HTMLURL)
+			otherReasons[otherPR.HTMLURL] = "via SHA"
 		}
 
-		// look in the cache for commit messages that include the
-		// SHA, indicating a reference during a cherry-pick
+		// look in the cache for commit messages that reference the SHA,
+		// either literally or via a cherry-pick/backport trailer
 		if len(otherIDs) == 0 {
-			cachedDetails, err := cache.getDetails(settings, clients,
-				settings.DownstreamOrg, repo)
+			cachedDetails, err := cache.getDetails(clients,
+				downstreamHost, forgeCfg.DownstreamOrg, ref.Repo)
 			if err != nil {
 				return nil, errors.Wrap(err,
 					fmt.Sprintf("could not build cache of details for %s/%s",
-						settings.DownstreamOrg, repo))
+						forgeCfg.DownstreamOrg, ref.Repo))
 			}
 			for _, pr := range cachedDetails.pullRequests {
-				for _, otherCommit := range cachedDetails.commits[*pr.Number] {
-					if strings.Contains(*otherCommit.Commit.Message, *c.SHA) {
-						if _, ok := otherIDs[*pr.Number]; ok {
-							// ignore duplicate PRs
-							continue
+				if _, ok := otherIDs[pr.Number]; ok {
+					// ignore duplicate PRs
+					continue
+				}
+				for _, otherCommit := range cachedDetails.commits[pr.Number] {
+					reason := ""
+					trailers := parseCommitTrailers(otherCommit.Message)
+					for _, sha := range trailerSHAs(trailers) {
+						if shasMatch(sha, c.SHA) {
+							reason = "via trailer"
+							break
 						}
-						otherLinks = append(otherLinks, *pr.HTMLURL)
+					}
+					if reason == "" && strings.Contains(otherCommit.Message, c.SHA) {
+						reason = "via SHA"
+					}
+					if reason != "" {
+						otherIDs[pr.Number] = true
+						otherLinks = append(otherLinks, pr.HTMLURL)
+						otherReasons[pr.HTMLURL] = reason
+						break
 					}
 				}
 			}
 		}
 	}
+
+	// Even without a shared commit, a downstream PR that references the
+	// same Jira issue counts as a match.
+	if issueKey != "" && !downstreamRepoMissing {
+		cachedDetails, err := cache.getDetails(clients,
+			downstreamHost, forgeCfg.DownstreamOrg, ref.Repo)
+		if err != nil {
+			return nil, errors.Wrap(err,
+				fmt.Sprintf("could not build cache of details for %s/%s",
+					forgeCfg.DownstreamOrg, ref.Repo))
+		}
+	prMatchLoop:
+		for _, pr := range cachedDetails.pullRequests {
+			if _, ok := otherIDs[pr.Number]; ok {
+				continue
+			}
+			for _, prCommit := range cachedDetails.commits[pr.Number] {
+				trailers := parseCommitTrailers(prCommit.Message)
+				for _, key := range trailerJiraKeys(trailers) {
+					if key == issueKey {
+						otherIDs[pr.Number] = true
+						otherLinks = append(otherLinks, pr.HTMLURL)
+						otherReasons[pr.HTMLURL] = "via jira key"
+						continue prMatchLoop
+					}
+				}
+			}
+		}
+	}
+
 	if len(otherLinks) > 0 {
-		otherResults, err := processLinks(settings, clients, cache, otherLinks)
+		otherResults, err := processLinks(settings, clients, cache, issueKey, otherLinks)
 		if err != nil {
 			return nil, errors.Wrap(err,
 				fmt.Sprintf("could not process %s", url))
 		}
+		for _, other := range otherResults {
+			other.matchReason = otherReasons[other.url]
+		}
 		result.others = otherResults
 	}
 
 	return result, nil
 }
 
-func processLinks(settings *appSettings, clients *serviceClients, cache *cache, links []string) ([]*linkResult, error) {
+func processLinks(settings *appSettings, clients *serviceClients, cache *cache, issueKey string, links []string) ([]*linkResult, error) {
 
 	var wg sync.WaitGroup
 	resultChan := make(chan *linkResult)
@@ -376,7 +559,7 @@ func processLinks(settings *appSettings, clients *serviceClients, cache *cache,
 		wg.Add(1)
 		go func(url string, ch chan<- *linkResult) {
 			defer wg.Done()
-			result, err := processOneLink(settings, clients, cache, url)
+			result, err := processOneLink(settings, clients, cache, issueKey, url)
 			if err != nil {
 				fmt.Printf("failed to get details for %s: %s\n", url, err)
 				return
@@ -403,32 +586,6 @@ func processLinks(settings *appSettings, clients *serviceClients, cache *cache,
 	return results, nil
 }
 
-func showLinkResults(settings *appSettings, results []*linkResult, indent string) {
-	for _, result := range results {
-
-		if result.org == settings.DownstreamOrg {
-			fmt.Printf("%sdownstream %s\n", indent, result.prWithStatus)
-			continue
-		}
-
-		fmt.Printf("%supstream %s\n", indent, result.prWithStatus)
-
-		if result.prWithStatus.status == "closed" {
-			// We don't care if there is no matching downstream PR if
-			// we closed the upstream one without merging it.
-			continue
-		}
-
-		if len(result.others) == 0 {
-			fmt.Printf("%s  downstream: no matching pull requests found in %s/%s\n",
-				indent, settings.DownstreamOrg, result.repo,
-			)
-			continue
-		}
-		showLinkResults(settings, result.others, indent+"  ")
-	}
-}
-
 func processOneIssue(settings *appSettings, clients *serviceClients, cache *cache, issueID string) (*issueResult, error) {
 	if settings.verbose {
 		fmt.Fprintf(os.Stderr, "getting details for %s\n", issueID)
@@ -443,7 +600,7 @@ func processOneIssue(settings *appSettings, clients *serviceClients, cache *cach
 
 	links := getLinks(issue)
 	if len(links) != 0 {
-		linkResults, err := processLinks(settings, clients, cache, links)
+		linkResults, err := processLinks(settings, clients, cache, issue.Key, links)
 		if err != nil {
 			return nil, errors.Wrap(err,
 				fmt.Sprintf("failed processing links in %s", issueID))
@@ -483,18 +640,6 @@ func processOneIssue(settings *appSettings, clients *serviceClients, cache *cach
 	return result, nil
 }
 
-func showOneIssueResult(settings *appSettings, result *issueResult, indent string) {
-	fmt.Printf("\n%s%s\n", indent, issueTitleLine(result.issue, settings.Jira.URL))
-	if len(result.linkResults) == 0 {
-		fmt.Printf("%s  no github links found\n", indent)
-	} else {
-		showLinkResults(settings, result.linkResults, indent+"  ")
-	}
-	for _, child := range result.children {
-		showOneIssueResult(settings, child, indent+"  ")
-	}
-}
-
 // fileExists checks if a file exists and is not a directory before we
 // try using it to prevent further errors.
 func fileExists(filename string) bool {
@@ -554,6 +699,12 @@ func main() {
 	configFilename := flag.String("config", configFilenameDefault,
 		"the configuration file name")
 	verbose := flag.Bool("v", false, "verbose mode")
+	jiraAuthorize := flag.Bool("jira-authorize", false,
+		"run the OAuth1 authorization handshake for jira.oauth1 and exit")
+	format := flag.String("format", "text",
+		"output format: text, json, html, or tmpl")
+	templatePath := flag.String("template", "",
+		"path to a text/template file, required when -format is tmpl")
 
 	flag.Parse()
 
@@ -578,36 +729,93 @@ func main() {
 	}
 	settings.verbose = *verbose
 
-	tp := jira.BasicAuthTransport{
-		Username: settings.Jira.User,
-		Password: settings.Jira.Password,
+	renderer, err := newRenderer(*format, *templatePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not build renderer: %v", err)
+		os.Exit(1)
+	}
+
+	if *jiraAuthorize {
+		if settings.Jira.OAuth1 == nil {
+			fmt.Fprintf(os.Stderr, "jira.oauth1 is not configured in %s", *configFilename)
+			os.Exit(1)
+		}
+		if err := authorizeOAuth1(settings.Jira.URL, settings.Jira.OAuth1); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not authorize with JIRA: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	jiraCredential, err := buildJiraCredential(&settings.Jira)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not build jira credential: %v", err)
+		os.Exit(1)
 	}
+	jiraHTTPClient := (&credentialTransport{credential: jiraCredential}).client()
 
-	jiraClient, err := jira.NewClient(tp.Client(), settings.Jira.URL)
+	jiraClient, err := jira.NewClient(jiraHTTPClient, settings.Jira.URL)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not create client: %v", err)
 		os.Exit(1)
 	}
 
-	ctx := context.Background()
-	tokenSource := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: settings.Github.Token},
-	)
-	oauthClient := oauth2.NewClient(ctx, tokenSource)
-	githubClient := github.NewClient(oauthClient)
+	forgeClients, err := buildForgeClients(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create forge clients: %v", err)
+		os.Exit(1)
+	}
 
 	clients := &serviceClients{
 		jira:   jiraClient,
-		github: githubClient,
+		forges: forgeClients,
+	}
+
+	disk, err := openDiskCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open disk cache, continuing without it: %v\n", err)
+	} else {
+		defer disk.close()
 	}
 
 	cache := &cache{
 		pullRequestsByRepo: make(map[string]repoPRCache),
+		disk:               disk,
+	}
+
+	if flag.Arg(0) == "backport" {
+		backportFlags := flag.NewFlagSet("backport", flag.ExitOnError)
+		backportDryRun := backportFlags.Bool("dry-run", false,
+			"print the git and API calls instead of running them")
+		backportContinue := backportFlags.Bool("continue", false,
+			"resume after resolving a cherry-pick conflict")
+		backportFlags.Usage = func() {
+			fmt.Fprintf(os.Stderr, "usage: %s backport [-dry-run] [-continue] ISSUE\n", os.Args[0])
+			backportFlags.PrintDefaults()
+		}
+		// flag.Parse above stopped at "backport", the first non-flag
+		// argument, so -dry-run/-continue are still unparsed in
+		// flag.Args()[1:] rather than having landed in the top-level
+		// FlagSet.
+		if err := backportFlags.Parse(flag.Args()[1:]); err != nil {
+			os.Exit(1)
+		}
+
+		issueID := backportFlags.Arg(0)
+		if issueID == "" {
+			backportFlags.Usage()
+			os.Exit(1)
+		}
+		if err := runBackport(settings, clients, cache, issueID, *backportDryRun, *backportContinue); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not backport %s: %v\n", issueID, err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	results := processIssues(settings, clients, cache, flag.Args())
-	for _, result := range results {
-		showOneIssueResult(settings, result, "")
+	if err := renderer.Render(os.Stdout, settings, results); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not render results: %v", err)
+		os.Exit(1)
 	}
-
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// Renderer turns a fully fetched issue/link result graph into output.
+// showOneIssueResult and showLinkResults used to write straight to stdout
+// with fmt.Printf; everything that used to live there now lives in
+// textRenderer so the same tree can also come out as JSON, HTML, or a
+// user-supplied template.
+type Renderer interface {
+	Render(w io.Writer, settings *appSettings, results []*issueResult) error
+}
+
+// newRenderer builds the Renderer selected by -format, loading the
+// template from -template when format is "tmpl".
+func newRenderer(format, templatePath string) (Renderer, error) {
+	switch format {
+	case "", "text":
+		return &textRenderer{}, nil
+	case "json":
+		return &jsonRenderer{}, nil
+	case "html":
+		return &htmlRenderer{}, nil
+	case "tmpl":
+		if templatePath == "" {
+			return nil, fmt.Errorf("-template is required when -format is %q", format)
+		}
+		return newTemplateRenderer(templatePath)
+	default:
+		return nil, fmt.Errorf("unknown -format %q", format)
+	}
+}
+
+// textRenderer reproduces mudlark's original plain-text indented tree.
+type textRenderer struct{}
+
+func (r *textRenderer) Render(w io.Writer, settings *appSettings, results []*issueResult) error {
+	for _, result := range results {
+		r.renderIssue(w, settings, result, "")
+	}
+	return nil
+}
+
+func (r *textRenderer) renderIssue(w io.Writer, settings *appSettings, result *issueResult, indent string) {
+	fmt.Fprintf(w, "\n%s%s\n", indent, issueTitleLine(result.issue, settings.Jira.URL))
+	if len(result.linkResults) == 0 {
+		fmt.Fprintf(w, "%s  no github links found\n", indent)
+	} else {
+		r.renderLinks(w, settings, result.linkResults, indent+"  ")
+	}
+	for _, child := range result.children {
+		r.renderIssue(w, settings, child, indent+"  ")
+	}
+}
+
+func (r *textRenderer) renderLinks(w io.Writer, settings *appSettings, results []*linkResult, indent string) {
+	for _, result := range results {
+		forgeCfg := settings.forgeFor(result.ref.Host)
+
+		if result.ref.Host == forgeCfg.downstreamHost() && result.ref.Org == forgeCfg.DownstreamOrg {
+			if result.matchReason != "" {
+				fmt.Fprintf(w, "%sdownstream %s (%s)\n", indent, result.prWithStatus, result.matchReason)
+			} else {
+				fmt.Fprintf(w, "%sdownstream %s\n", indent, result.prWithStatus)
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "%supstream %s\n", indent, result.prWithStatus)
+
+		if result.prWithStatus.status == "closed" {
+			continue
+		}
+
+		if len(result.others) == 0 {
+			fmt.Fprintf(w, "%s  downstream: no matching pull requests found in %s/%s\n",
+				indent, forgeCfg.DownstreamOrg, result.ref.Repo,
+			)
+			continue
+		}
+		r.renderLinks(w, settings, result.others, indent+"  ")
+	}
+}
+
+// jsonPullRequest is the stable JSON shape for a single pull/merge
+// request.
+type jsonPullRequest struct {
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Status  string `json:"status"`
+	BaseRef string `json:"baseRef"`
+}
+
+// jsonLinkResult is the stable JSON shape for a linkResult.
+type jsonLinkResult struct {
+	URL         string           `json:"url"`
+	Org         string           `json:"org"`
+	Repo        string           `json:"repo"`
+	Downstream  bool             `json:"downstream"`
+	MatchReason string           `json:"matchReason,omitempty"`
+	PullRequest jsonPullRequest  `json:"pullRequest"`
+	Others      []jsonLinkResult `json:"others,omitempty"`
+}
+
+// jsonIssueResult is the stable JSON shape for an issueResult.
+type jsonIssueResult struct {
+	Key      string            `json:"key"`
+	Type     string            `json:"type"`
+	Status   string            `json:"status"`
+	Summary  string            `json:"summary"`
+	URL      string            `json:"url"`
+	Links    []jsonLinkResult  `json:"links,omitempty"`
+	Children []jsonIssueResult `json:"children,omitempty"`
+}
+
+func toJSONLinkResult(settings *appSettings, result *linkResult) jsonLinkResult {
+	others := make([]jsonLinkResult, len(result.others))
+	for i, other := range result.others {
+		others[i] = toJSONLinkResult(settings, other)
+	}
+
+	return jsonLinkResult{
+		URL:         result.url,
+		Org:         result.ref.Org,
+		Repo:        result.ref.Repo,
+		Downstream:  isDownstream(settings, result),
+		MatchReason: result.matchReason,
+		PullRequest: jsonPullRequest{
+			URL:     result.prWithStatus.pull.HTMLURL,
+			Title:   result.prWithStatus.pull.Title,
+			Status:  result.prWithStatus.status,
+			BaseRef: result.prWithStatus.pull.BaseRef,
+		},
+		Others: others,
+	}
+}
+
+func toJSONIssueResult(settings *appSettings, result *issueResult) jsonIssueResult {
+	links := make([]jsonLinkResult, len(result.linkResults))
+	for i, link := range result.linkResults {
+		links[i] = toJSONLinkResult(settings, link)
+	}
+
+	children := make([]jsonIssueResult, len(result.children))
+	for i, child := range result.children {
+		children[i] = toJSONIssueResult(settings, child)
+	}
+
+	return jsonIssueResult{
+		Key:      result.issue.Key,
+		Type:     result.issue.Fields.Type.Name,
+		Status:   result.issue.Fields.Status.Name,
+		Summary:  result.issue.Fields.Summary,
+		URL:      issueURL(settings, result.issue),
+		Links:    links,
+		Children: children,
+	}
+}
+
+type jsonRenderer struct{}
+
+func (r *jsonRenderer) Render(w io.Writer, settings *appSettings, results []*issueResult) error {
+	out := make([]jsonIssueResult, len(results))
+	for i, result := range results {
+		out[i] = toJSONIssueResult(settings, result)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// issueURL returns the JIRA browse URL for an issue. It is also exposed to
+// -format tmpl templates via the FuncMap built in templateRenderer.Render.
+func issueURL(settings *appSettings, issue *jira.Issue) string {
+	return fmt.Sprintf("%s/browse/%s", settings.Jira.URL, issue.Key)
+}
+
+// isDownstream reports whether a linkResult's pull/merge request lives in
+// its forge's configured downstream host/org.
+func isDownstream(settings *appSettings, result *linkResult) bool {
+	forgeCfg := settings.forgeFor(result.ref.Host)
+	return result.ref.Host == forgeCfg.downstreamHost() && result.ref.Org == forgeCfg.DownstreamOrg
+}
+
+// mergedAt returns a human-readable merge time, or "" if the pull/merge
+// request hasn't been merged (or the forge didn't report one).
+func mergedAt(result *linkResult) string {
+	if result.prWithStatus.status != "merged" || result.prWithStatus.pull.MergedAt == nil {
+		return ""
+	}
+	return result.prWithStatus.pull.MergedAt.Format(time.RFC3339)
+}
+
+// missingDownstream reports whether an upstream linkResult is merged (or
+// still open) but has no matching downstream pull/merge request.
+func missingDownstream(settings *appSettings, result *linkResult) bool {
+	if isDownstream(settings, result) {
+		return false
+	}
+	if result.prWithStatus.status == "closed" {
+		return false
+	}
+	return len(result.others) == 0
+}
+
+// matchReason reports how a downstream linkResult was matched to its
+// upstream counterpart: "via SHA", "via trailer", "via jira key", or "" if
+// it wasn't found as a downstream match (e.g. a top-level link).
+func matchReason(result *linkResult) string {
+	return result.matchReason
+}
+
+const htmlRendererTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mudlark report</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; }
+  h2 { border-bottom: 1px solid #ccc; padding-bottom: 0.2em; }
+  .issue { margin-bottom: 1.5em; }
+  .badge { display: inline-block; padding: 0.1em 0.5em; border-radius: 0.3em; color: white; font-size: 0.85em; margin-right: 0.4em; }
+  .badge-upstream { background: #2b6cb0; }
+  .badge-downstream { background: #2f855a; }
+  .badge-missing { background: #c53030; }
+  ul { list-style: none; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<h1>mudlark report</h1>
+{{range .}}
+<div class="issue">
+  <h2><a href="{{.URL}}">{{.Key}}</a> {{.Type}} &mdash; {{.Status}}: {{.Summary}}</h2>
+  {{template "links" .Links}}
+</div>
+{{end}}
+</body>
+</html>
+{{define "links"}}
+<ul>
+  {{range .}}
+  <li>
+    {{if .Downstream}}<span class="badge badge-downstream">downstream{{if .MatchReason}} &mdash; {{.MatchReason}}{{end}}</span>{{else}}<span class="badge badge-upstream">upstream</span>{{end}}
+    <a href="{{.PullRequest.URL}}">{{.PullRequest.Title}}</a> ({{.PullRequest.Status}} on {{.PullRequest.BaseRef}})
+    {{if .Others}}{{template "links" .Others}}{{else if not .Downstream}}<span class="badge badge-missing">no downstream match</span>{{end}}
+  </li>
+  {{end}}
+</ul>
+{{end}}
+`
+
+// htmlRenderer produces a single self-contained HTML page grouping issues
+// by status, with color-coded upstream/downstream pull request badges.
+type htmlRenderer struct{}
+
+func (r *htmlRenderer) Render(w io.Writer, settings *appSettings, results []*issueResult) error {
+	tmpl, err := htmltemplate.New("report").Parse(htmlRendererTemplate)
+	if err != nil {
+		return err
+	}
+
+	out := make([]jsonIssueResult, len(results))
+	for i, result := range results {
+		out[i] = toJSONIssueResult(settings, result)
+	}
+	// Keep issues with the same status together, in the caller's order
+	// within each status.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Status < out[j].Status })
+
+	return tmpl.Execute(w, out)
+}
+
+// templateRenderer executes a user-supplied text/template against the
+// result tree, with issueURL, isDownstream, mergedAt, and
+// missingDownstream available as template functions. Parsing is deferred
+// to Render, since text/template resolves referenced function names at
+// Parse time and issueURL/isDownstream/missingDownstream need settings,
+// which isn't known until then.
+type templateRenderer struct {
+	name    string
+	content string
+}
+
+func newTemplateRenderer(path string) (*templateRenderer, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &templateRenderer{name: filepath.Base(path), content: string(content)}, nil
+}
+
+func (r *templateRenderer) Render(w io.Writer, settings *appSettings, results []*issueResult) error {
+	funcMap := texttemplate.FuncMap{
+		"issueURL": func(result *issueResult) string {
+			return issueURL(settings, result.issue)
+		},
+		"isDownstream":      func(result *linkResult) bool { return isDownstream(settings, result) },
+		"mergedAt":          mergedAt,
+		"missingDownstream": func(result *linkResult) bool { return missingDownstream(settings, result) },
+		"matchReason":       matchReason,
+	}
+
+	tmpl, err := texttemplate.New(r.name).Funcs(funcMap).Parse(r.content)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, results)
+}
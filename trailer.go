@@ -0,0 +1,113 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// commitTrailer is a single "Key: value" footer line from a commit
+// message, or the equivalent parsed out of git's own
+// "(cherry picked from commit <sha>)" line.
+type commitTrailer struct {
+	Key   string
+	Value string
+}
+
+var trailerLinePattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*):\s*(.+)$`)
+var cherryPickLinePattern = regexp.MustCompile(`^\(cherry picked from commit ([0-9a-fA-F]{7,40})\)$`)
+
+// shaTrailerKeys are the trailer keys whose value is expected to be a
+// commit SHA.
+var shaTrailerKeys = map[string]bool{
+	"cherry-picked-from": true,
+	"upstream-commit":    true,
+	"backport-of":        true,
+}
+
+// jiraTrailerKeys are the trailer keys whose value is expected to be a
+// JIRA issue key.
+var jiraTrailerKeys = map[string]bool{
+	"jira":     true,
+	"resolves": true,
+	"fixes":    true,
+}
+
+var shaValuePattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+var jiraKeyValuePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]+-\d+$`)
+
+// parseCommitTrailers walks a commit message from the bottom up and
+// returns the trailer block at its end: a contiguous run of "Key: value"
+// lines (and/or git's own "(cherry picked from commit <sha>)" line),
+// stopping at the first blank line encountered going upward. A message
+// with no trailing trailer block returns an empty slice. A single-line message (no
+// blank line at all, e.g. a subject of just "Fixes: PROJ-123") is treated
+// as being entirely its own trailer block, since there's no blank line to
+// stop the upward scan at.
+func parseCommitTrailers(message string) []commitTrailer {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+
+	end := len(lines)
+	start := end
+	for start > 0 && strings.TrimSpace(lines[start-1]) != "" {
+		start--
+	}
+
+	trailers := []commitTrailer{}
+	for _, line := range lines[start:end] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m := cherryPickLinePattern.FindStringSubmatch(line); m != nil {
+			trailers = append(trailers, commitTrailer{Key: "Cherry-picked-from", Value: m[1]})
+			continue
+		}
+		if m := trailerLinePattern.FindStringSubmatch(line); m != nil {
+			trailers = append(trailers, commitTrailer{Key: m[1], Value: strings.TrimSpace(m[2])})
+		}
+	}
+	return trailers
+}
+
+// trailerSHAs returns the commit SHAs referenced by a message's
+// cherry-pick/backport trailers.
+func trailerSHAs(trailers []commitTrailer) []string {
+	shas := []string{}
+	for _, t := range trailers {
+		if !shaTrailerKeys[strings.ToLower(t.Key)] {
+			continue
+		}
+		if shaValuePattern.MatchString(t.Value) {
+			shas = append(shas, t.Value)
+		}
+	}
+	return shas
+}
+
+// trailerJiraKeys returns the JIRA issue keys referenced by a message's
+// Jira/Resolves/Fixes trailers.
+func trailerJiraKeys(trailers []commitTrailer) []string {
+	keys := []string{}
+	for _, t := range trailers {
+		if !jiraTrailerKeys[strings.ToLower(t.Key)] {
+			continue
+		}
+		if jiraKeyValuePattern.MatchString(t.Value) {
+			keys = append(keys, t.Value)
+		}
+	}
+	return keys
+}
+
+// shasMatch reports whether two (possibly abbreviated) SHAs refer to the
+// same commit: one must be a prefix of the other.
+func shasMatch(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	shorter, longer := a, b
+	if len(longer) < len(shorter) {
+		shorter, longer = longer, shorter
+	}
+	return strings.HasPrefix(longer, shorter)
+}
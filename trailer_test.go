@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommitTrailers(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  string
+		expected []commitTrailer
+	}{
+		{
+			name:     "subject and body only",
+			message:  "Fix the widget\n\nThe widget was broken because of X.\n",
+			expected: []commitTrailer{},
+		},
+		{
+			name:    "trailer block after blank line",
+			message: "Fix the widget\n\nThe widget was broken because of X.\n\nFixes: PROJ-123\n(cherry picked from commit abcdef0123456789abcdef0123456789abcdef01)\n",
+			expected: []commitTrailer{
+				{Key: "Fixes", Value: "PROJ-123"},
+				{Key: "Cherry-picked-from", Value: "abcdef0123456789abcdef0123456789abcdef01"},
+			},
+		},
+		{
+			name:    "trailer immediately below subject with no body",
+			message: "Fix the widget\n\nFixes: PROJ-123\n",
+			expected: []commitTrailer{
+				{Key: "Fixes", Value: "PROJ-123"},
+			},
+		},
+		{
+			name:    "single-line message shaped like a trailer",
+			message: "Fixes: PROJ-123",
+			expected: []commitTrailer{
+				{Key: "Fixes", Value: "PROJ-123"},
+			},
+		},
+		{
+			name:     "single-line message with no trailer shape",
+			message:  "Fix the widget",
+			expected: []commitTrailer{},
+		},
+		{
+			name:     "no trailing trailer block",
+			message:  "Fix the widget\n\nJust prose, no trailers here.\n",
+			expected: []commitTrailer{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual := parseCommitTrailers(c.message)
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Errorf("parseCommitTrailers(%q) = %#v, want %#v", c.message, actual, c.expected)
+			}
+		})
+	}
+}
+
+func TestTrailerSHAs(t *testing.T) {
+	trailers := []commitTrailer{
+		{Key: "Cherry-picked-from", Value: "abcdef0123456789abcdef0123456789abcdef01"},
+		{Key: "Upstream-Commit", Value: "1234567"},
+		{Key: "Backport-of", Value: "not-a-sha"},
+		{Key: "Fixes", Value: "PROJ-123"},
+	}
+
+	actual := trailerSHAs(trailers)
+	expected := []string{"abcdef0123456789abcdef0123456789abcdef01", "1234567"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("trailerSHAs() = %#v, want %#v", actual, expected)
+	}
+}
+
+func TestTrailerJiraKeys(t *testing.T) {
+	trailers := []commitTrailer{
+		{Key: "Jira", Value: "PROJ-123"},
+		{Key: "Resolves", Value: "OTHER-45"},
+		{Key: "Fixes", Value: "not-a-key"},
+		{Key: "Cherry-picked-from", Value: "abcdef0"},
+	}
+
+	actual := trailerJiraKeys(trailers)
+	expected := []string{"PROJ-123", "OTHER-45"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("trailerJiraKeys() = %#v, want %#v", actual, expected)
+	}
+}
+
+func TestShasMatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		a, b     string
+		expected bool
+	}{
+		{"identical full shas", "abcdef0123456789abcdef0123456789abcdef01", "abcdef0123456789abcdef0123456789abcdef01", true},
+		{"abbreviated prefix matches full", "abcdef0", "abcdef0123456789abcdef0123456789abcdef01", true},
+		{"full matches abbreviated prefix", "abcdef0123456789abcdef0123456789abcdef01", "abcdef0", true},
+		{"different shas", "abcdef0", "1234567", false},
+		{"empty a", "", "abcdef0", false},
+		{"empty b", "abcdef0", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if actual := shasMatch(c.a, c.b); actual != c.expected {
+				t.Errorf("shasMatch(%q, %q) = %v, want %v", c.a, c.b, actual, c.expected)
+			}
+		})
+	}
+}